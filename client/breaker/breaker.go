@@ -0,0 +1,209 @@
+package breaker
+
+import (
+	"sync"
+	"time"
+)
+
+type State int
+
+const (
+	// StateClosed lets all calls through
+	StateClosed State = iota
+	// StateOpen rejects all calls until Settings.OpenTimeout has passed
+	StateOpen
+	// StateHalfOpen lets a limited number of trial calls through to check whether the service has recovered
+	StateHalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// Settings configures the circuit breakers created by the client
+type Settings struct {
+	// FailureThreshold is the number of consecutive failures after which the circuit opens
+	FailureThreshold int
+
+	// OpenTimeout is the time that the circuit stays open before letting trial calls through
+	OpenTimeout time.Duration
+
+	// HalfOpenMaxCalls is the number of trial calls that are let through while the circuit is half-open.
+	// The circuit closes once all of them succeed, and opens again as soon as one of them fails.
+	HalfOpenMaxCalls int
+
+	// PerPath makes the client keep a circuit breaker for every path instead of one for every service
+	PerPath bool
+
+	// OnStateChange is called whenever the circuit of a service (or path, if PerPath is true) changes state.
+	// It can be used to export metrics about the state of the dependencies.
+	OnStateChange func(name string, from, to State)
+}
+
+// DefaultSettings returns settings that open a circuit after 5 consecutive failures for 10 seconds
+func DefaultSettings() *Settings {
+	return &Settings{
+		FailureThreshold: 5,
+		OpenTimeout:      10 * time.Second,
+		HalfOpenMaxCalls: 1,
+	}
+}
+
+// Breaker is a circuit breaker for a single target
+type Breaker struct {
+	name     string
+	settings *Settings
+	onChange func(name string, from, to State)
+
+	mu        sync.Mutex
+	state     State
+	failures  int
+	trials    int
+	successes int
+	openedAt  time.Time
+	now       func() time.Time
+
+	// pending holds the changes of state that haven't been passed to the callbacks yet, which are called once the lock
+	// is released so that they can use the breaker and take their time
+	pending []transition
+}
+
+type transition struct {
+	from, to State
+}
+
+// New creates a circuit breaker in the closed state. onChange is called after the settings' OnStateChange function,
+// without holding the breaker's lock.
+func New(name string, settings *Settings, onChange func(name string, from, to State)) *Breaker {
+	return &Breaker{
+		name:     name,
+		settings: settings,
+		onChange: onChange,
+		now:      time.Now,
+	}
+}
+
+// State returns the current state of the circuit
+func (b *Breaker) State() State {
+	defer b.notify()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.checkOpenTimeout()
+	return b.state
+}
+
+// Allow returns true if a call can be made. If it does, Success or Failure must be called once the call completes.
+func (b *Breaker) Allow() bool {
+	defer b.notify()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.checkOpenTimeout()
+
+	switch b.state {
+	case StateOpen:
+		return false
+
+	case StateHalfOpen:
+		if b.trials >= b.halfOpenMaxCalls() {
+			return false
+		}
+		b.trials++
+	}
+
+	return true
+}
+
+// Success records a call that succeeded
+func (b *Breaker) Success() {
+	defer b.notify()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case StateClosed:
+		b.failures = 0
+
+	case StateHalfOpen:
+		b.successes++
+		if b.successes >= b.halfOpenMaxCalls() {
+			b.setState(StateClosed)
+		}
+	}
+}
+
+// Failure records a call that failed
+func (b *Breaker) Failure() {
+	defer b.notify()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case StateClosed:
+		b.failures++
+		if b.failures >= b.settings.FailureThreshold {
+			b.setState(StateOpen)
+		}
+
+	case StateHalfOpen:
+		b.setState(StateOpen)
+	}
+}
+
+func (b *Breaker) halfOpenMaxCalls() int {
+	if b.settings.HalfOpenMaxCalls < 1 {
+		return 1
+	}
+	return b.settings.HalfOpenMaxCalls
+}
+
+func (b *Breaker) checkOpenTimeout() {
+	if b.state == StateOpen && b.now().Sub(b.openedAt) >= b.settings.OpenTimeout {
+		b.setState(StateHalfOpen)
+	}
+}
+
+func (b *Breaker) setState(s State) {
+	from := b.state
+
+	b.state = s
+	b.failures = 0
+	b.trials = 0
+	b.successes = 0
+
+	if s == StateOpen {
+		b.openedAt = b.now()
+	}
+
+	b.pending = append(b.pending, transition{from, s})
+}
+
+// notify passes the pending changes of state to the callbacks. It must be called without holding the lock.
+func (b *Breaker) notify() {
+	b.mu.Lock()
+	pending := b.pending
+	b.pending = nil
+	b.mu.Unlock()
+
+	for _, t := range pending {
+		if b.settings.OnStateChange != nil {
+			b.settings.OnStateChange(b.name, t.from, t.to)
+		}
+		if b.onChange != nil {
+			b.onChange(b.name, t.from, t.to)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package breaker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBreaker(t *testing.T) {
+	now := time.Now()
+
+	var changes []State
+	b := New("svc", &Settings{
+		FailureThreshold: 2,
+		OpenTimeout:      time.Second,
+		HalfOpenMaxCalls: 1,
+	}, func(name string, from, to State) {
+		assert.Equal(t, "svc", name)
+		changes = append(changes, to)
+	})
+	b.now = func() time.Time { return now }
+
+	assert.True(t, b.Allow())
+	b.Failure()
+	assert.Equal(t, StateClosed, b.State())
+
+	assert.True(t, b.Allow())
+	b.Failure()
+	assert.Equal(t, StateOpen, b.State())
+	assert.False(t, b.Allow())
+
+	now = now.Add(time.Second)
+	assert.Equal(t, StateHalfOpen, b.State())
+
+	assert.True(t, b.Allow())
+	assert.False(t, b.Allow(), "only one trial call is allowed")
+	b.Failure()
+	assert.Equal(t, StateOpen, b.State())
+
+	now = now.Add(time.Second)
+	assert.True(t, b.Allow())
+	b.Success()
+	assert.Equal(t, StateClosed, b.State())
+
+	assert.Equal(t, []State{StateOpen, StateHalfOpen, StateOpen, StateHalfOpen, StateClosed}, changes)
+}
+
+func TestBreakerSuccessResetsFailures(t *testing.T) {
+	b := New("svc", &Settings{FailureThreshold: 2}, nil)
+
+	b.Failure()
+	b.Success()
+	b.Failure()
+
+	assert.Equal(t, StateClosed, b.State())
+}
+
+func TestBreakerCallbackUsesBreaker(t *testing.T) {
+	var b *Breaker
+	var states []State
+
+	b = New("svc", &Settings{
+		FailureThreshold: 1,
+		OpenTimeout:      time.Minute,
+		OnStateChange: func(name string, from, to State) {
+			// The lock isn't held while the callbacks run
+			states = append(states, b.State())
+		},
+	}, func(name string, from, to State) {
+		b.Allow()
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		b.Failure()
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the callbacks deadlocked")
+	}
+
+	assert.Equal(t, []State{StateOpen}, states)
+}
@@ -1,10 +1,20 @@
 package client
 
-import "context"
+import (
+	"context"
+	"time"
+
+	"github.com/MouseHatGames/mice/client/retry"
+	"github.com/MouseHatGames/mice/middleware"
+)
 
 // CallOptions represents configuration that apply to a single call
 type CallOptions struct {
-	Context context.Context
+	Context     context.Context
+	Timeout     time.Duration
+	Middlewares []middleware.ClientMiddleware
+	Retry       *retry.Policy
+	Idempotent  bool
 }
 
 type CallOption func(*CallOptions)
@@ -15,3 +25,33 @@ func Context(c context.Context) CallOption {
 		o.Context = c
 	}
 }
+
+// Timeout sets the maximum time to wait for the call to complete, overriding the service's default call timeout.
+// The remaining time is sent to the called service so that it can abandon the request once it expires.
+func Timeout(d time.Duration) CallOption {
+	return func(o *CallOptions) {
+		o.Timeout = d
+	}
+}
+
+// WithMiddleware adds one or more middlewares that will wrap a call, inside of the ones declared in the service's options
+func WithMiddleware(mws ...middleware.ClientMiddleware) CallOption {
+	return func(o *CallOptions) {
+		o.Middlewares = append(o.Middlewares, mws...)
+	}
+}
+
+// Retry sets the policy used to retry the call if it fails, overriding the service's default retry policy
+func Retry(p *retry.Policy) CallOption {
+	return func(o *CallOptions) {
+		o.Retry = p
+	}
+}
+
+// Idempotent marks the call as safe to be made more than once. Calls that aren't idempotent are only retried if the
+// request couldn't have reached the service, for example when it fails to connect.
+func Idempotent() CallOption {
+	return func(o *CallOptions) {
+		o.Idempotent = true
+	}
+}
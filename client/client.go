@@ -3,35 +3,84 @@ package client
 import (
 	"context"
 	"crypto/rand"
-	"errors"
+	goerrors "errors"
 	"fmt"
+	"net"
 	"reflect"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/MouseHatGames/mice/auth"
 	"github.com/MouseHatGames/mice/broker"
+	"github.com/MouseHatGames/mice/client/breaker"
+	"github.com/MouseHatGames/mice/client/retry"
+	"github.com/MouseHatGames/mice/codec"
+	"github.com/MouseHatGames/mice/errors"
+	"github.com/MouseHatGames/mice/middleware"
 	"github.com/MouseHatGames/mice/options"
 	"github.com/MouseHatGames/mice/tracing"
 	"github.com/MouseHatGames/mice/transport"
 )
 
-var ErrMustBeFunc = errors.New("value must be a function")
-var ErrInvalidInput = errors.New("func must have 1 input")
-var ErrInputPointer = errors.New("the func must take a pointer as an input")
+var ErrMustBeFunc = goerrors.New("value must be a function")
+var ErrInvalidInput = goerrors.New("func must have 1 input")
+var ErrInputPointer = goerrors.New("the func must take a pointer as an input")
+
+// ErrCircuitOpen is returned when a call isn't made because the target service has been failing repeatedly
+var ErrCircuitOpen = goerrors.New("circuit breaker is open")
+
+// transportError wraps an error that occurred while communicating with a service
+type transportError struct {
+	err error
+
+	// sent is true if the request may have reached the service before the error occurred
+	sent bool
+}
+
+func (e *transportError) Error() string {
+	return e.err.Error()
+}
+
+func (e *transportError) Unwrap() error {
+	return e.err
+}
+
+// sendError wraps an error returned when sending a message. Some transports only connect once the message is sent,
+// so a failure to connect means that the request never reached the service.
+func sendError(err error) *transportError {
+	return &transportError{fmt.Errorf("send message: %w", err), !isConnectError(err)}
+}
+
+// isConnectError returns true if err was caused by a failure to establish a connection
+func isConnectError(err error) bool {
+	var operr *net.OpError
+	if goerrors.As(err, &operr) && operr.Op == "dial" {
+		return true
+	}
+
+	return goerrors.Is(err, syscall.ECONNREFUSED)
+}
 
 type Client interface {
 	Call(service string, path string, req interface{}, resp interface{}, opts ...CallOption) error
+	Stream(service string, path string, opts ...CallOption) (Stream, error)
 	Subscribe(topic string, callback interface{})
 }
 
 type client struct {
 	opts *options.Options
 	port int16
+
+	breakers   map[string]*breaker.Breaker
+	breakersMu sync.Mutex
 }
 
 func NewClient(opts *options.Options) Client {
 	return &client{
-		opts: opts,
-		port: opts.RPCPort,
+		opts:     opts,
+		port:     opts.RPCPort,
+		breakers: make(map[string]*breaker.Breaker),
 	}
 }
 
@@ -42,13 +91,159 @@ func (c *client) Call(service string, path string, reqval interface{}, respval i
 		o(&callopts)
 	}
 
-	parentReq, hasParent := transport.GetContextRequest(callopts.Context)
-
 	ctx := callopts.Context
 	if ctx == nil {
 		ctx = context.Background()
 	}
 
+	timeout := callopts.Timeout
+	if timeout == 0 {
+		timeout = c.opts.CallTimeout
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	mws := make([]middleware.ClientMiddleware, 0, len(c.opts.ClientMiddlewares)+len(callopts.Middlewares))
+	mws = append(mws, c.opts.ClientMiddlewares...)
+	mws = append(mws, callopts.Middlewares...)
+
+	call := middleware.ChainClient(c.retrying(&callopts), mws...)
+
+	return call(ctx, &middleware.Call{
+		Service:  service,
+		Path:     path,
+		Request:  reqval,
+		Response: respval,
+		Headers:  make(transport.MessageHeaders),
+	})
+}
+
+// retrying returns a CallFunc that makes a call, retrying it according to the call's or the service's retry policy
+func (c *client) retrying(callopts *CallOptions) middleware.CallFunc {
+	policy := callopts.Retry
+	if policy == nil {
+		policy = c.opts.RetryPolicy
+	}
+	if policy == nil || policy.MaxAttempts <= 1 {
+		return c.guardedCall
+	}
+
+	return func(ctx context.Context, call *middleware.Call) error {
+		for attempt := 1; ; attempt++ {
+			err := c.guardedCall(ctx, call)
+			if err == nil || attempt >= policy.MaxAttempts || ctx.Err() != nil || !shouldRetry(err, policy, callopts.Idempotent) {
+				return err
+			}
+
+			wait := policy.Backoff(attempt)
+
+			// Overloaded services may hint how long to wait before trying again
+			var merr *errors.Error
+			if goerrors.As(err, &merr) && merr.RetryAfter > wait {
+				wait = merr.RetryAfter
+			}
+
+			// Don't bother waiting if the call will time out before the next attempt
+			if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+				return err
+			}
+
+			c.opts.Logger.Debugf("call to %s.%s failed, retrying in %s: %s", call.Service, call.Path, wait, err)
+
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return err
+			}
+		}
+	}
+}
+
+func shouldRetry(err error, policy *retry.Policy, idempotent bool) bool {
+	var terr *transportError
+	if goerrors.As(err, &terr) {
+		return !terr.sent || idempotent
+	}
+
+	if !idempotent {
+		return false
+	}
+
+	var merr *errors.Error
+	return goerrors.As(err, &merr) && policy.RetriesStatus(merr.StatusCode)
+}
+
+// guardedCall makes a call if the target's circuit breaker allows it
+func (c *client) guardedCall(ctx context.Context, call *middleware.Call) error {
+	b := c.getBreaker(call)
+	if b == nil {
+		return c.call(ctx, call)
+	}
+
+	if !b.Allow() {
+		return fmt.Errorf("call %s.%s: %w", call.Service, call.Path, ErrCircuitOpen)
+	}
+
+	err := c.call(ctx, call)
+	if isFailure(err) {
+		b.Failure()
+	} else {
+		b.Success()
+	}
+
+	return err
+}
+
+// getBreaker returns the circuit breaker for a call's target, or nil if circuit breaking is disabled
+func (c *client) getBreaker(call *middleware.Call) *breaker.Breaker {
+	settings := c.opts.CircuitBreaker
+	if settings == nil {
+		return nil
+	}
+
+	name := call.Service
+	if settings.PerPath {
+		name += "/" + call.Path
+	}
+
+	c.breakersMu.Lock()
+	defer c.breakersMu.Unlock()
+
+	b, ok := c.breakers[name]
+	if !ok {
+		b = breaker.New(name, settings, func(name string, from, to breaker.State) {
+			c.opts.Logger.Infof("circuit breaker for %s changed from %s to %s", name, from, to)
+		})
+		c.breakers[name] = b
+	}
+
+	return b
+}
+
+// isFailure returns true if err means that the called service is unhealthy, as opposed to an error returned by a
+// healthy service because of the request, like a 404
+func isFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var terr *transportError
+	if goerrors.As(err, &terr) {
+		return true
+	}
+
+	var merr *errors.Error
+	return goerrors.As(err, &merr) && merr.StatusCode >= 500
+}
+
+func (c *client) call(ctx context.Context, call *middleware.Call) error {
+	parentReq, hasParent := transport.GetContextRequest(ctx)
+
 	ctx = tracing.ExtractFromMessage(ctx, parentReq)
 
 	if c.opts.Discovery == nil {
@@ -56,7 +251,7 @@ func (c *client) Call(service string, path string, reqval interface{}, respval i
 	}
 
 	// Find service address
-	host, err := c.opts.Discovery.Find(service)
+	host, err := c.opts.Discovery.Find(call.Service)
 	if err != nil {
 		return fmt.Errorf("discover service: %w", err)
 	}
@@ -64,52 +259,121 @@ func (c *client) Call(service string, path string, reqval interface{}, respval i
 	// Connect to service
 	s, err := c.opts.Transport.Dial(ctx, fmt.Sprintf("%s:%d", host, c.port))
 	if err != nil {
-		return fmt.Errorf("dial: %w", err)
+		return &transportError{fmt.Errorf("dial: %w", err), false}
 	}
 	defer s.Close()
 
 	req := transport.NewMessage()
+	for k, v := range call.Headers {
+		req.MessageHeaders[k] = v
+	}
+
 	req.SetRandomRequestID()
-	req.SetPath(path)
+	req.SetPath(call.Path)
 
 	if id, ok := auth.GetUserID(ctx); ok {
 		req.SetUserID(id)
 	}
 
+	// Propagate the deadline, which may come from this call's timeout or from the request being handled
+	if deadline, ok := ctx.Deadline(); ok {
+		req.SetTimeout(time.Until(deadline))
+	}
+
 	tracing.InjectToMessage(ctx, req)
 
 	if hasParent {
 		req.MessageHeaders[transport.HeaderParentRequestID] = parentReq.MessageHeaders[transport.HeaderRequestID]
 	}
 
+	cod := c.opts.Codec
+	req.SetAccept(c.opts.ContentTypes())
+
+	respmsg, err := c.exchange(ctx, s, req, cod, call.Request)
+	if err != nil {
+		return err
+	}
+
+	// The service doesn't support our codec, so try again with one that it does
+	if fallback, ok := c.fallbackCodec(respmsg, cod); ok {
+		c.opts.Logger.Debugf("%s doesn't support %s, falling back to %s", call.Service, codec.ContentType(cod), codec.ContentType(fallback))
+
+		cod = fallback
+		req.SetRandomRequestID()
+
+		if respmsg, err = c.exchange(ctx, s, req, cod, call.Request); err != nil {
+			return err
+		}
+	}
+
+	// Check for server handler error
+	if err, ok := respmsg.GetError(); ok {
+		return err
+	}
+
+	// The response may have been encoded with another codec if the service prefers one that we also accept
+	if ct, ok := respmsg.GetContentType(); ok {
+		if cod, ok = c.opts.GetCodec(ct); !ok {
+			return fmt.Errorf("decode response: unsupported content type %q", ct)
+		}
+	}
+
+	// Decode response data
+	if err := cod.Unmarshal(respmsg.Data, call.Response); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+
+	return nil
+}
+
+// exchange encodes a request with a codec, sends it and waits for its response
+func (c *client) exchange(ctx context.Context, s transport.Socket, req *transport.Message, cod codec.Codec, reqval interface{}) (*transport.Message, error) {
+	var err error
+
 	// Encode request data
-	req.Data, err = c.opts.Codec.Marshal(reqval)
+	req.Data, err = cod.Marshal(reqval)
 	if err != nil {
-		return fmt.Errorf("encode request: %w", err)
+		return nil, fmt.Errorf("encode request: %w", err)
 	}
+	req.SetContentType(codec.ContentType(cod))
 
 	// Send request
 	if err := s.Send(ctx, req); err != nil {
-		return fmt.Errorf("send message: %w", err)
+		return nil, sendError(err)
 	}
 
 	// Receive response
 	var respmsg transport.Message
 	if err := s.Receive(ctx, &respmsg); err != nil {
-		return fmt.Errorf("receive message: %w", err)
+		return nil, &transportError{fmt.Errorf("receive message: %w", err), true}
 	}
 
-	// Check for server handler error
-	if err, ok := respmsg.GetError(); ok {
-		return err
+	return &respmsg, nil
+}
+
+// fallbackCodec returns the codec that a request should be sent again with if the response says that the codec it
+// was encoded with isn't supported by the service
+func (c *client) fallbackCodec(resp *transport.Message, used codec.Codec) (codec.Codec, bool) {
+	err, ok := resp.GetError()
+	if !ok {
+		return nil, false
 	}
 
-	// Decode response data
-	if err := c.opts.Codec.Unmarshal(respmsg.Data, respval); err != nil {
-		return fmt.Errorf("decode response: %w", err)
+	if merr, ok := err.(*errors.Error); !ok || merr.StatusCode != 415 {
+		return nil, false
 	}
 
-	return nil
+	for _, ct := range resp.GetAccept() {
+		if ct == codec.ContentType(used) {
+			continue
+		}
+
+		if cod, ok := c.opts.GetCodec(ct); ok {
+			return cod, true
+		}
+	}
+
+	return nil, false
 }
 
 func (c *client) Subscribe(topic string, callback interface{}) {
@@ -146,7 +410,7 @@ func (c *client) createCallback(intf interface{}) (func(*broker.Message), error)
 	// hasctx := typ.NumIn() == 2
 
 	// if hasctx && typ.In(0) != reflect.TypeOf(context.Background()) {
-	// 	return nil, errors.New("the first input must be of type context.Context")
+	// 	return nil, goerrors.New("the first input must be of type context.Context")
 	// }
 
 	if typ.In(0).Kind() != reflect.Ptr {
@@ -1,11 +1,26 @@
 package client
 
 import (
+	"context"
+	goerrors "errors"
+	"net"
+	nethttp "net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/MouseHatGames/mice/broker"
+	"github.com/MouseHatGames/mice/client/breaker"
+	"github.com/MouseHatGames/mice/client/retry"
+	"github.com/MouseHatGames/mice/errors"
+	"github.com/MouseHatGames/mice/logger/stdout"
+	"github.com/MouseHatGames/mice/middleware"
 	"github.com/MouseHatGames/mice/options"
+	"github.com/MouseHatGames/mice/transport"
+	"github.com/MouseHatGames/mice/transport/http"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 type dummy struct {
@@ -16,6 +31,10 @@ type mockcodec struct {
 	n int
 }
 
+func (*mockcodec) ContentType() string {
+	return "application/mock"
+}
+
 func (*mockcodec) Marshal(msg interface{}) ([]byte, error) {
 	return nil, nil
 }
@@ -65,3 +84,215 @@ func TestCreateCallback(t *testing.T) {
 		assert.True(t, called)
 	})
 }
+
+func TestCallMiddleware(t *testing.T) {
+	var calls []string
+
+	mw := func(name string) middleware.ClientMiddleware {
+		return func(next middleware.CallFunc) middleware.CallFunc {
+			return func(ctx context.Context, call *middleware.Call) error {
+				assert.Equal(t, "svc", call.Service)
+				assert.Equal(t, "handler.Method", call.Path)
+
+				calls = append(calls, name)
+
+				// Don't call next so that no discovery or transport is needed
+				if name == "call" {
+					call.Response.(*dummy).n = 123
+					return nil
+				}
+				return next(ctx, call)
+			}
+		}
+	}
+
+	c := NewClient(&options.Options{
+		ClientMiddlewares: []middleware.ClientMiddleware{mw("global")},
+	})
+
+	var resp dummy
+	err := c.Call("svc", "handler.Method", &dummy{}, &resp, WithMiddleware(mw("call")))
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"global", "call"}, calls)
+	assert.Equal(t, 123, resp.n)
+}
+
+type mockDiscovery struct{}
+
+func (mockDiscovery) Find(svc string) (string, error) {
+	return svc, nil
+}
+
+// mockTransport fails to dial the first failDials times, and then returns sockets that respond with respErr
+type mockTransport struct {
+	failDials int
+	respErr   error
+	dials     int
+}
+
+func (t *mockTransport) Listen(ctx context.Context, addr string) (transport.Listener, error) {
+	panic("not implemented")
+}
+
+func (t *mockTransport) Dial(ctx context.Context, addr string) (transport.Socket, error) {
+	t.dials++
+	if t.dials <= t.failDials {
+		return nil, goerrors.New("connection refused")
+	}
+	return &mockSocket{respErr: t.respErr}, nil
+}
+
+type mockSocket struct {
+	respErr error
+}
+
+func (*mockSocket) Close() error {
+	return nil
+}
+
+func (*mockSocket) Send(ctx context.Context, msg *transport.Message) error {
+	return nil
+}
+
+func (s *mockSocket) Receive(ctx context.Context, msg *transport.Message) error {
+	if s.respErr != nil {
+		msg.SetError(s.respErr)
+	}
+	return nil
+}
+
+func newMockClient(tr transport.Transport, opts ...options.Option) Client {
+	o := &options.Options{
+		Codec:     &mockcodec{},
+		Logger:    stdout.NewStdoutLogger(" "),
+		Discovery: mockDiscovery{},
+		Transport: tr,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return NewClient(o)
+}
+
+func TestCallRetry(t *testing.T) {
+	policy := &retry.Policy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+		StatusCodes:    []int16{503},
+	}
+
+	t.Run("dial errors", func(t *testing.T) {
+		tr := &mockTransport{failDials: 2}
+		c := newMockClient(tr, options.RetryPolicy(policy))
+
+		err := c.Call("svc", "handler.Method", &dummy{}, &dummy{})
+
+		assert.Nil(t, err)
+		assert.Equal(t, 3, tr.dials)
+	})
+	t.Run("too many dial errors", func(t *testing.T) {
+		tr := &mockTransport{failDials: 5}
+		c := newMockClient(tr, options.RetryPolicy(policy))
+
+		err := c.Call("svc", "handler.Method", &dummy{}, &dummy{})
+
+		assert.NotNil(t, err)
+		assert.Equal(t, 3, tr.dials)
+	})
+	t.Run("status code not idempotent", func(t *testing.T) {
+		tr := &mockTransport{respErr: errors.NewError(503, "unavailable")}
+		c := newMockClient(tr, options.RetryPolicy(policy))
+
+		err := c.Call("svc", "handler.Method", &dummy{}, &dummy{})
+
+		assert.NotNil(t, err)
+		assert.Equal(t, 1, tr.dials)
+	})
+	t.Run("status code idempotent", func(t *testing.T) {
+		tr := &mockTransport{respErr: errors.NewError(503, "unavailable")}
+		c := newMockClient(tr)
+
+		err := c.Call("svc", "handler.Method", &dummy{}, &dummy{}, Retry(policy), Idempotent())
+
+		assert.NotNil(t, err)
+		assert.Equal(t, 3, tr.dials)
+	})
+	t.Run("status code not retried", func(t *testing.T) {
+		tr := &mockTransport{respErr: errors.NewError(404, "not found")}
+		c := newMockClient(tr)
+
+		err := c.Call("svc", "handler.Method", &dummy{}, &dummy{}, Retry(policy), Idempotent())
+
+		assert.NotNil(t, err)
+		assert.Equal(t, 1, tr.dials)
+	})
+}
+
+// redirectTransport dials the first addresses in order and then the last one, regardless of the address it's given
+type redirectTransport struct {
+	transport.Transport
+	addrs []string
+	dials int
+}
+
+func (t *redirectTransport) Dial(ctx context.Context, addr string) (transport.Socket, error) {
+	addr = t.addrs[len(t.addrs)-1]
+	if t.dials < len(t.addrs) {
+		addr = t.addrs[t.dials]
+	}
+	t.dials++
+
+	return t.Transport.Dial(ctx, addr)
+}
+
+func TestCallRetryConnectionRefused(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	closed := ln.Addr().String()
+	ln.Close()
+
+	srv := httptest.NewServer(nethttp.HandlerFunc(func(rw nethttp.ResponseWriter, r *nethttp.Request) {}))
+	defer srv.Close()
+
+	o := &options.Options{Logger: stdout.NewStdoutLogger(" ")}
+	http.Transport()(o)
+
+	// The HTTP transport only connects when sending, so a service that is restarting refuses the request then
+	tr := &redirectTransport{
+		Transport: o.Transport,
+		addrs:     []string{closed, closed, strings.TrimPrefix(srv.URL, "http://")},
+	}
+
+	c := newMockClient(tr, options.RetryPolicy(&retry.Policy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+	}))
+
+	err = c.Call("svc", "handler.Method", &dummy{}, &dummy{})
+
+	assert.Nil(t, err, "calls that weren't idempotent are retried too")
+	assert.Equal(t, 3, tr.dials)
+}
+
+func TestCallCircuitBreaker(t *testing.T) {
+	tr := &mockTransport{failDials: 100}
+	c := newMockClient(tr, options.CircuitBreaker(&breaker.Settings{
+		FailureThreshold: 2,
+		OpenTimeout:      time.Minute,
+	}))
+
+	for i := 0; i < 2; i++ {
+		err := c.Call("svc", "handler.Method", &dummy{}, &dummy{})
+		assert.NotErrorIs(t, err, ErrCircuitOpen)
+	}
+
+	err := c.Call("svc", "handler.Method", &dummy{}, &dummy{})
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	assert.Equal(t, 2, tr.dials)
+
+	// Other services have their own breaker
+	err = c.Call("other", "handler.Method", &dummy{}, &dummy{})
+	assert.NotErrorIs(t, err, ErrCircuitOpen)
+}
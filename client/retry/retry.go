@@ -0,0 +1,72 @@
+package retry
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Policy describes when and how failed calls are retried
+type Policy struct {
+	// MaxAttempts is the maximum number of times a call will be attempted, including the first one
+	MaxAttempts int
+
+	// InitialBackoff is the time to wait before the first retry
+	InitialBackoff time.Duration
+
+	// MaxBackoff is the maximum time to wait between two attempts
+	MaxBackoff time.Duration
+
+	// Multiplier is the factor by which the backoff is multiplied after every attempt
+	Multiplier float64
+
+	// Jitter is the fraction of the backoff, between 0 and 1, that will be randomly subtracted from it
+	// so that clients that failed at the same time don't retry in lockstep
+	Jitter float64
+
+	// StatusCodes contains the errors.Error status codes that will be retried. Since receiving a status code means that
+	// the request reached the other service, they are only retried for calls marked as idempotent.
+	StatusCodes []int16
+}
+
+// DefaultPolicy returns a policy that makes up to 3 attempts with a backoff starting at 100ms,
+// retrying on 503 Service Unavailable errors.
+func DefaultPolicy() *Policy {
+	return &Policy{
+		MaxAttempts:    3,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     2 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.2,
+		StatusCodes:    []int16{503},
+	}
+}
+
+// Backoff returns the time to wait after the given attempt has failed, starting from 1
+func (p *Policy) Backoff(attempt int) time.Duration {
+	mult := p.Multiplier
+	if mult < 1 {
+		mult = 1
+	}
+
+	d := float64(p.InitialBackoff) * math.Pow(mult, float64(attempt-1))
+	if p.MaxBackoff > 0 && d > float64(p.MaxBackoff) {
+		d = float64(p.MaxBackoff)
+	}
+
+	if p.Jitter > 0 {
+		d -= d * p.Jitter * rand.Float64()
+	}
+
+	return time.Duration(d)
+}
+
+// RetriesStatus returns true if errors with the given status code should be retried
+func (p *Policy) RetriesStatus(code int16) bool {
+	for _, c := range p.StatusCodes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,35 @@
+package retry
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoff(t *testing.T) {
+	p := &Policy{
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     time.Second,
+		Multiplier:     2,
+	}
+
+	assert.Equal(t, 100*time.Millisecond, p.Backoff(1))
+	assert.Equal(t, 200*time.Millisecond, p.Backoff(2))
+	assert.Equal(t, 400*time.Millisecond, p.Backoff(3))
+	assert.Equal(t, time.Second, p.Backoff(10))
+}
+
+func TestBackoffJitter(t *testing.T) {
+	p := &Policy{
+		InitialBackoff: 100 * time.Millisecond,
+		Multiplier:     2,
+		Jitter:         0.5,
+	}
+
+	for i := 0; i < 100; i++ {
+		d := p.Backoff(1)
+
+		assert.True(t, d > 50*time.Millisecond && d <= 100*time.Millisecond, "backoff %s out of range", d)
+	}
+}
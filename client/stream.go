@@ -0,0 +1,338 @@
+package client
+
+import (
+	"context"
+	goerrors "errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/MouseHatGames/mice/auth"
+	"github.com/MouseHatGames/mice/codec"
+	"github.com/MouseHatGames/mice/tracing"
+	"github.com/MouseHatGames/mice/transport"
+	"github.com/google/uuid"
+)
+
+// ErrStreamClosed is returned when using a stream that has already been closed
+var ErrStreamClosed = goerrors.New("stream is closed")
+
+// ErrStreamOverflow is returned when the endpoint sends more messages than the flow control allows
+var ErrStreamOverflow = goerrors.New("endpoint sent messages without waiting for acknowledgements")
+
+// Stream is the client side of a stream to a streaming endpoint
+type Stream interface {
+	// Context returns the context of the stream, which is done once the stream is closed
+	Context() context.Context
+
+	// Send encodes a message and sends it to the endpoint. If the endpoint has transport.StreamWindow messages that it
+	// hasn't read yet, Send waits until it reads them. It returns io.EOF if the endpoint returns in the meantime.
+	Send(msg interface{}) error
+
+	// Receive waits for a message from the endpoint and decodes it into msg, which must be a pointer.
+	// It returns io.EOF once the endpoint has returned, or the error it returned if there was one.
+	Receive(msg interface{}) error
+
+	// CloseSend lets the endpoint know that no more messages will be sent, without closing the stream
+	CloseSend() error
+
+	// Close closes the stream, cancelling the endpoint if it hasn't returned yet
+	Close() error
+}
+
+type clientStream struct {
+	client *client
+	soc    transport.Socket
+	id     uuid.UUID
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// flow limits the messages sent to the endpoint and acknowledges the ones received from it. sendCtx is done once
+	// the endpoint can't acknowledge any more messages, so that senders don't wait forever.
+	flow     *transport.StreamFlow
+	sendCtx  context.Context
+	stopSend context.CancelFunc
+
+	// inbox receives the messages sent by the endpoint, which are read from the socket in the background so that
+	// acknowledgements are handled even when nobody is receiving. It's closed once nothing else can be received,
+	// after setting inboxErr.
+	inbox    chan *transport.Message
+	inboxErr error
+
+	// err is the error that Receive returns once the stream is over
+	err   error
+	errMu sync.Mutex
+
+	closeOnce sync.Once
+}
+
+var _ Stream = (*clientStream)(nil)
+
+// Stream opens a stream to a streaming endpoint. Middlewares, retries and the service's default call timeout don't
+// apply to streams, but the Context and Timeout call options do.
+func (c *client) Stream(service string, path string, opts ...CallOption) (Stream, error) {
+	var callopts CallOptions
+
+	for _, o := range opts {
+		o(&callopts)
+	}
+
+	ctx := callopts.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	var cancel context.CancelFunc
+	if callopts.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, callopts.Timeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+
+	st, err := c.openStream(ctx, service, path)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	st.cancel = cancel
+
+	return st, nil
+}
+
+func (c *client) openStream(ctx context.Context, service string, path string) (*clientStream, error) {
+	parentReq, hasParent := transport.GetContextRequest(ctx)
+
+	ctx = tracing.ExtractFromMessage(ctx, parentReq)
+
+	if c.opts.Discovery == nil {
+		panic("no discovery has been set up")
+	}
+
+	host, err := c.opts.Discovery.Find(service)
+	if err != nil {
+		return nil, fmt.Errorf("discover service: %w", err)
+	}
+
+	s, err := c.opts.Transport.Dial(ctx, fmt.Sprintf("%s:%d", host, c.port))
+	if err != nil {
+		return nil, &transportError{fmt.Errorf("dial: %w", err), false}
+	}
+
+	id := uuid.New()
+
+	req := transport.NewMessage()
+	req.SetRequestID(id)
+	req.SetPath(path)
+	req.SetStream(transport.StreamOpen)
+	req.SetContentType(codec.ContentType(c.opts.Codec))
+	req.SetAccept(c.opts.ContentTypes())
+
+	if uid, ok := auth.GetUserID(ctx); ok {
+		req.SetUserID(uid)
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		req.SetTimeout(time.Until(deadline))
+	}
+
+	tracing.InjectToMessage(ctx, req)
+
+	if hasParent {
+		req.MessageHeaders[transport.HeaderParentRequestID] = parentReq.MessageHeaders[transport.HeaderRequestID]
+	}
+
+	if err := s.Send(ctx, req); err != nil {
+		s.Close()
+		return nil, sendError(err)
+	}
+
+	sendCtx, stopSend := context.WithCancel(ctx)
+
+	st := &clientStream{
+		client:   c,
+		soc:      s,
+		id:       id,
+		ctx:      ctx,
+		flow:     transport.NewStreamFlow(),
+		sendCtx:  sendCtx,
+		stopSend: stopSend,
+
+		// There's also room for the message that closes the stream
+		inbox: make(chan *transport.Message, transport.StreamWindow+1),
+	}
+	go st.receiveLoop()
+
+	return st, nil
+}
+
+// receiveLoop reads the messages sent by the endpoint until the stream is over
+func (s *clientStream) receiveLoop() {
+	defer close(s.inbox)
+	defer s.stopSend()
+
+	for {
+		m := new(transport.Message)
+
+		if err := s.soc.Receive(s.ctx, m); err != nil {
+			if goerrors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			s.inboxErr = &transportError{fmt.Errorf("receive message: %w", err), true}
+			return
+		}
+
+		kind, _ := m.GetStream()
+		if kind == transport.StreamAck {
+			if n, ok := m.GetCredit(); ok {
+				s.flow.Grant(n)
+			}
+			continue
+		}
+
+		select {
+		case s.inbox <- m:
+		default:
+			// Waiting for room would stop acknowledgements from being handled, and the endpoint shouldn't have sent
+			// this many messages anyway
+			s.inboxErr = ErrStreamOverflow
+			return
+		}
+
+		if _, ok := m.GetError(); ok || kind == transport.StreamClose {
+			return
+		}
+	}
+}
+
+func (s *clientStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *clientStream) Send(msg interface{}) error {
+	if err := s.getErr(); err != nil {
+		return err
+	}
+
+	data, err := s.client.opts.Codec.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("encode message: %w", err)
+	}
+
+	if err := s.flow.Acquire(s.sendCtx); err != nil {
+		if s.ctx.Err() == nil {
+			// The endpoint has returned, Receive tells how
+			return io.EOF
+		}
+		return &transportError{fmt.Errorf("wait for acknowledgement: %w", err), true}
+	}
+
+	m := s.newMessage(transport.StreamData)
+	m.SetContentType(codec.ContentType(s.client.opts.Codec))
+	m.Data = data
+
+	if err := s.soc.Send(s.ctx, m); err != nil {
+		return &transportError{fmt.Errorf("send message: %w", err), true}
+	}
+
+	return nil
+}
+
+func (s *clientStream) Receive(msg interface{}) error {
+	if err := s.getErr(); err != nil {
+		return err
+	}
+
+	m, ok := <-s.inbox
+	if !ok {
+		return s.setErr(s.inboxErr)
+	}
+
+	if err, ok := m.GetError(); ok {
+		return s.setErr(err)
+	}
+
+	if kind, _ := m.GetStream(); kind == transport.StreamClose {
+		return s.setErr(io.EOF)
+	}
+
+	if n := s.flow.Consume(); n > 0 {
+		ack := s.newMessage(transport.StreamAck)
+		ack.SetCredit(n)
+
+		if err := s.soc.Send(s.ctx, ack); err != nil {
+			return s.setErr(&transportError{fmt.Errorf("send acknowledgement: %w", err), true})
+		}
+	}
+
+	cod := s.client.opts.Codec
+	if ct, ok := m.GetContentType(); ok {
+		if cod, ok = s.client.opts.GetCodec(ct); !ok {
+			return fmt.Errorf("decode message: unsupported content type %q", ct)
+		}
+	}
+
+	if err := cod.Unmarshal(m.Data, msg); err != nil {
+		return fmt.Errorf("decode message: %w", err)
+	}
+
+	return nil
+}
+
+func (s *clientStream) CloseSend() error {
+	if err := s.getErr(); err != nil {
+		return err
+	}
+
+	if err := s.soc.Send(s.ctx, s.newMessage(transport.StreamClose)); err != nil {
+		return &transportError{fmt.Errorf("send message: %w", err), true}
+	}
+
+	return nil
+}
+
+func (s *clientStream) Close() error {
+	var err error
+
+	s.closeOnce.Do(func() {
+		// Let the endpoint know that nobody is listening anymore if it's still running
+		if s.getErr() == nil {
+			if serr := s.soc.Send(s.ctx, s.newMessage(transport.StreamCancel)); serr != nil {
+				s.client.opts.Logger.Debugf("send stream cancel: %s", serr)
+			}
+		}
+
+		s.setErr(ErrStreamClosed)
+		s.cancel()
+
+		err = s.soc.Close()
+	})
+
+	return err
+}
+
+func (s *clientStream) newMessage(kind string) *transport.Message {
+	m := transport.NewMessage()
+	m.SetRequestID(s.id)
+	m.SetStream(kind)
+	return m
+}
+
+// setErr sets the error that will be returned from now on if there isn't one already, and returns it
+func (s *clientStream) setErr(err error) error {
+	s.errMu.Lock()
+	defer s.errMu.Unlock()
+
+	if s.err == nil {
+		s.err = err
+	}
+	return s.err
+}
+
+func (s *clientStream) getErr() error {
+	s.errMu.Lock()
+	defer s.errMu.Unlock()
+
+	return s.err
+}
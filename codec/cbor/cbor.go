@@ -0,0 +1,28 @@
+package cbor
+
+import (
+	"github.com/MouseHatGames/mice/options"
+	"github.com/fxamacker/cbor/v2"
+)
+
+type cborCodec struct{}
+
+// Codec sets a CBOR codec as the service's codec, see Options.SetCodec. Struct fields can be configured with
+// cbor tags, falling back to their json tags.
+func Codec() options.Option {
+	return func(o *options.Options) {
+		o.SetCodec(&cborCodec{})
+	}
+}
+
+func (*cborCodec) ContentType() string {
+	return "application/cbor"
+}
+
+func (*cborCodec) Marshal(msg interface{}) ([]byte, error) {
+	return cbor.Marshal(msg)
+}
+
+func (*cborCodec) Unmarshal(b []byte, out interface{}) error {
+	return cbor.Unmarshal(b, out)
+}
@@ -0,0 +1,59 @@
+package cbor
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type tagged struct {
+	Name  string `json:"name"`
+	Score int    `json:"score,omitempty"`
+	Skip  string `json:"-"`
+}
+
+func TestRoundTrip(t *testing.T) {
+	c := &cborCodec{}
+
+	b, err := c.Marshal(&tagged{Name: "mouse", Score: 10, Skip: "secret"})
+	require.Nil(t, err)
+
+	var out tagged
+	require.Nil(t, c.Unmarshal(b, &out))
+
+	assert.Equal(t, tagged{Name: "mouse", Score: 10}, out)
+}
+
+func TestJSONTags(t *testing.T) {
+	c := &cborCodec{}
+
+	b, err := c.Marshal(&tagged{Name: "mouse"})
+	require.Nil(t, err)
+
+	var out map[string]interface{}
+	require.Nil(t, c.Unmarshal(b, &out))
+
+	assert.Equal(t, map[string]interface{}{"name": "mouse"}, out)
+}
+
+type overridden struct {
+	Name string `cbor:"n" json:"name"`
+}
+
+func TestCodecTagsWin(t *testing.T) {
+	c := &cborCodec{}
+
+	b, err := c.Marshal(&overridden{Name: "mouse"})
+	require.Nil(t, err)
+
+	var out map[string]interface{}
+	require.Nil(t, c.Unmarshal(b, &out))
+
+	assert.Equal(t, map[string]interface{}{"n": "mouse"}, out, "the cbor tag is used over the json tag")
+
+	var back overridden
+	require.Nil(t, c.Unmarshal(b, &back))
+
+	assert.Equal(t, overridden{Name: "mouse"}, back)
+}
@@ -1,6 +1,29 @@
 package codec
 
+import "reflect"
+
 type Codec interface {
 	Marshal(msg interface{}) ([]byte, error)
 	Unmarshal(b []byte, out interface{}) error
 }
+
+// ContentTyper can be implemented by codecs to tell the MIME type of the data they produce, which is used to pick the
+// right codec when services that use different ones talk to each other
+type ContentTyper interface {
+	ContentType() string
+}
+
+// ContentType returns the content type of a codec, or an empty string if it doesn't implement ContentTyper
+func ContentType(c Codec) string {
+	if ct, ok := c.(ContentTyper); ok {
+		return ct.ContentType()
+	}
+	return ""
+}
+
+// TypeChecker can be implemented by codecs that only support some types, so that handlers that use other types
+// are detected when they're registered instead of when they receive their first request
+type TypeChecker interface {
+	// CheckType returns an error if values of type t can't be encoded and decoded
+	CheckType(t reflect.Type) error
+}
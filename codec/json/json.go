@@ -8,12 +8,17 @@ import (
 
 type jsonCodec struct{}
 
+// Codec sets a JSON codec as the service's codec, see Options.SetCodec
 func Codec() options.Option {
 	return func(o *options.Options) {
-		o.Codec = &jsonCodec{}
+		o.SetCodec(&jsonCodec{})
 	}
 }
 
+func (*jsonCodec) ContentType() string {
+	return "application/json"
+}
+
 func (*jsonCodec) Marshal(msg interface{}) ([]byte, error) {
 	return json.Marshal(msg)
 }
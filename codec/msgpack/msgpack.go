@@ -0,0 +1,45 @@
+package msgpack
+
+import (
+	"bytes"
+
+	"github.com/MouseHatGames/mice/options"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// structTag is the struct tag that is used when a field has no msgpack tag, so that types can be shared with the JSON codec
+const structTag = "json"
+
+type msgpackCodec struct{}
+
+// Codec sets a MessagePack codec as the service's codec, see Options.SetCodec. Struct fields can be configured
+// with msgpack tags, falling back to their json tags.
+func Codec() options.Option {
+	return func(o *options.Options) {
+		o.SetCodec(&msgpackCodec{})
+	}
+}
+
+func (*msgpackCodec) ContentType() string {
+	return "application/msgpack"
+}
+
+func (*msgpackCodec) Marshal(msg interface{}) ([]byte, error) {
+	var b bytes.Buffer
+
+	enc := msgpack.NewEncoder(&b)
+	enc.SetCustomStructTag(structTag)
+
+	if err := enc.Encode(msg); err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+}
+
+func (*msgpackCodec) Unmarshal(b []byte, out interface{}) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(b))
+	dec.SetCustomStructTag(structTag)
+
+	return dec.Decode(out)
+}
@@ -0,0 +1,55 @@
+package proto
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/MouseHatGames/mice/options"
+	protobuf "google.golang.org/protobuf/proto"
+)
+
+// ErrNotProtoMessage is returned when encoding or decoding a value that doesn't implement proto.Message
+var ErrNotProtoMessage = errors.New("value is not a proto.Message")
+
+var messageType = reflect.TypeOf((*protobuf.Message)(nil)).Elem()
+
+type protoCodec struct{}
+
+// Codec sets a Protocol Buffers codec as the service's codec, see Options.SetCodec. All requests and responses
+// must be generated protobuf messages, which is checked when handlers are added.
+func Codec() options.Option {
+	return func(o *options.Options) {
+		o.SetCodec(&protoCodec{})
+	}
+}
+
+func (*protoCodec) ContentType() string {
+	return "application/x-protobuf"
+}
+
+func (*protoCodec) Marshal(msg interface{}) ([]byte, error) {
+	m, ok := msg.(protobuf.Message)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T", ErrNotProtoMessage, msg)
+	}
+
+	return protobuf.Marshal(m)
+}
+
+func (*protoCodec) Unmarshal(b []byte, out interface{}) error {
+	m, ok := out.(protobuf.Message)
+	if !ok {
+		return fmt.Errorf("%w: %T", ErrNotProtoMessage, out)
+	}
+
+	return protobuf.Unmarshal(b, m)
+}
+
+func (*protoCodec) CheckType(t reflect.Type) error {
+	if !t.Implements(messageType) {
+		return fmt.Errorf("%w: %s", ErrNotProtoMessage, t)
+	}
+
+	return nil
+}
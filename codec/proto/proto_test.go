@@ -0,0 +1,39 @@
+package proto
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestRoundTrip(t *testing.T) {
+	c := &protoCodec{}
+
+	b, err := c.Marshal(wrapperspb.String("hello"))
+	require.Nil(t, err)
+
+	var out wrapperspb.StringValue
+	require.Nil(t, c.Unmarshal(b, &out))
+
+	assert.Equal(t, "hello", out.Value)
+}
+
+func TestNotProtoMessage(t *testing.T) {
+	c := &protoCodec{}
+
+	_, err := c.Marshal(&struct{}{})
+	assert.ErrorIs(t, err, ErrNotProtoMessage)
+
+	err = c.Unmarshal([]byte{}, &struct{}{})
+	assert.ErrorIs(t, err, ErrNotProtoMessage)
+}
+
+func TestCheckType(t *testing.T) {
+	c := &protoCodec{}
+
+	assert.Nil(t, c.CheckType(reflect.TypeOf(&wrapperspb.StringValue{})))
+	assert.ErrorIs(t, c.CheckType(reflect.TypeOf(&struct{}{})), ErrNotProtoMessage)
+}
@@ -6,11 +6,15 @@ import (
 	"encoding/gob"
 	"fmt"
 	"net/http"
+	"time"
 )
 
 type Error struct {
 	StatusCode int16
 	ID, Detail string
+
+	// RetryAfter is how long the caller should wait before retrying the request, if it's known
+	RetryAfter time.Duration
 }
 
 func NewError(code int16, format string, a ...interface{}) *Error {
@@ -1,6 +1,9 @@
 package errors
 
-import "testing"
+import (
+	"testing"
+	"time"
+)
 
 func TestEncodeDecode(t *testing.T) {
 	err := NewError(123, "hello %d", 10)
@@ -23,3 +26,17 @@ func TestEncodeDecode(t *testing.T) {
 		t.Fatalf("ids differ: %s and %s", err2.ID, err.ID)
 	}
 }
+
+func TestEncodeRetryAfter(t *testing.T) {
+	err := ServiceUnavailable(3*time.Second, "busy")
+
+	str, _ := err.(*Error).Encode()
+	err2, ok := Decode(str)
+	if !ok {
+		t.Fatalf("failed to decode")
+	}
+
+	if err2.RetryAfter != 3*time.Second {
+		t.Fatalf("retry after differs: %s", err2.RetryAfter)
+	}
+}
@@ -1,5 +1,7 @@
 package errors
 
+import "time"
+
 func BadRequest(format string, a ...interface{}) error {
 	return NewError(400, format, a...)
 }
@@ -39,3 +41,16 @@ func InternalServerError(format string, a ...interface{}) error {
 func InternalServerErrorID(id string, format string, a ...interface{}) error {
 	return NewErrorID(id, 500, format, a...)
 }
+
+// ServiceUnavailable returns a 503 error, hinting the caller to retry after the given duration
+func ServiceUnavailable(retryAfter time.Duration, format string, a ...interface{}) error {
+	err := NewError(503, format, a...)
+	err.RetryAfter = retryAfter
+	return err
+}
+
+func ServiceUnavailableID(id string, retryAfter time.Duration, format string, a ...interface{}) error {
+	err := NewErrorID(id, 503, format, a...)
+	err.RetryAfter = retryAfter
+	return err
+}
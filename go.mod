@@ -1,12 +1,16 @@
 module github.com/MouseHatGames/mice
 
-go 1.15
+go 1.16
 
 require (
+	github.com/fxamacker/cbor/v2 v2.5.0
 	github.com/google/uuid v1.3.0
+	github.com/klauspost/compress v1.15.9
 	github.com/kr/pretty v0.1.0 // indirect
 	github.com/stretchr/testify v1.7.1
+	github.com/vmihailenco/msgpack/v5 v5.3.5
 	go.opentelemetry.io/otel v1.9.0
 	go.opentelemetry.io/otel/trace v1.9.0
+	google.golang.org/protobuf v1.28.1
 	gopkg.in/check.v1 v1.0.0-20180628173108-788fd7840127 // indirect
 )
@@ -0,0 +1,166 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	PathHealth    = "/healthz"
+	PathReadiness = "/readyz"
+	PathLiveness  = "/livez"
+)
+
+// DefaultCheckTimeout is the maximum time that all checks are given to complete when serving a health request
+const DefaultCheckTimeout = 5 * time.Second
+
+// Check is a function that returns a non-nil error if the resource it checks is unhealthy
+type Check func(ctx context.Context) error
+
+// Checker can be implemented by components (broker, config, discovery, etc) to have their health checked automatically
+type Checker interface {
+	HealthCheck(ctx context.Context) error
+}
+
+// Health keeps track of the checks that determine whether a service is healthy and ready to receive requests
+type Health interface {
+	// Register adds a check with a name, replacing any other check that had the same name
+	Register(name string, check Check)
+
+	// SetReady sets whether the service is ready to receive requests
+	SetReady(ready bool)
+	Ready() bool
+
+	// Check runs all registered checks and returns the errors of the ones that failed, keyed by name
+	Check(ctx context.Context) map[string]error
+
+	// Handler returns an HTTP handler that serves the health, readiness and liveness endpoints
+	Handler() http.Handler
+}
+
+type health struct {
+	checks map[string]Check
+	mu     sync.RWMutex
+	ready  int32
+}
+
+func NewHealth() Health {
+	return &health{
+		checks: make(map[string]Check),
+	}
+}
+
+func (h *health) Register(name string, check Check) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.checks[name] = check
+}
+
+func (h *health) SetReady(ready bool) {
+	var v int32
+	if ready {
+		v = 1
+	}
+	atomic.StoreInt32(&h.ready, v)
+}
+
+func (h *health) Ready() bool {
+	return atomic.LoadInt32(&h.ready) == 1
+}
+
+func (h *health) Check(ctx context.Context) map[string]error {
+	h.mu.RLock()
+	checks := make(map[string]Check, len(h.checks))
+	for k, v := range h.checks {
+		checks[k] = v
+	}
+	h.mu.RUnlock()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	failed := make(map[string]error)
+
+	for name, check := range checks {
+		wg.Add(1)
+
+		go func(name string, check Check) {
+			defer wg.Done()
+
+			if err := check(ctx); err != nil {
+				mu.Lock()
+				failed[name] = err
+				mu.Unlock()
+			}
+		}(name, check)
+	}
+
+	wg.Wait()
+	return failed
+}
+
+func (h *health) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc(PathLiveness, func(rw http.ResponseWriter, r *http.Request) {
+		writeStatus(rw, http.StatusOK, nil)
+	})
+
+	mux.HandleFunc(PathHealth, func(rw http.ResponseWriter, r *http.Request) {
+		h.serveChecks(rw, r)
+	})
+
+	mux.HandleFunc(PathReadiness, func(rw http.ResponseWriter, r *http.Request) {
+		if !h.Ready() {
+			writeStatus(rw, http.StatusServiceUnavailable, nil)
+			return
+		}
+
+		h.serveChecks(rw, r)
+	})
+
+	return mux
+}
+
+func (h *health) serveChecks(rw http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), DefaultCheckTimeout)
+	defer cancel()
+
+	failed := h.Check(ctx)
+
+	if len(failed) > 0 {
+		writeStatus(rw, http.StatusServiceUnavailable, failed)
+	} else {
+		writeStatus(rw, http.StatusOK, nil)
+	}
+}
+
+type statusResponse struct {
+	Status string            `json:"status"`
+	Failed map[string]string `json:"failed,omitempty"`
+}
+
+func writeStatus(rw http.ResponseWriter, code int, failed map[string]error) {
+	resp := statusResponse{
+		Status: "ok",
+	}
+
+	if code != http.StatusOK {
+		resp.Status = "unavailable"
+	}
+
+	if len(failed) > 0 {
+		resp.Failed = make(map[string]string, len(failed))
+		for k, err := range failed {
+			resp.Failed[k] = err.Error()
+		}
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(code)
+	json.NewEncoder(rw).Encode(resp)
+}
@@ -0,0 +1,45 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func serve(h Health, path string) int {
+	rec := httptest.NewRecorder()
+	h.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	return rec.Code
+}
+
+func TestReadiness(t *testing.T) {
+	h := NewHealth()
+
+	assert.Equal(t, http.StatusServiceUnavailable, serve(h, PathReadiness))
+
+	h.SetReady(true)
+	assert.Equal(t, http.StatusOK, serve(h, PathReadiness))
+
+	h.SetReady(false)
+	assert.Equal(t, http.StatusServiceUnavailable, serve(h, PathReadiness))
+}
+
+func TestChecks(t *testing.T) {
+	h := NewHealth()
+	h.SetReady(true)
+
+	h.Register("ok", func(ctx context.Context) error { return nil })
+	assert.Equal(t, http.StatusOK, serve(h, PathHealth))
+
+	checkerr := errors.New("down")
+	h.Register("db", func(ctx context.Context) error { return checkerr })
+
+	assert.Equal(t, map[string]error{"db": checkerr}, h.Check(context.Background()))
+	assert.Equal(t, http.StatusServiceUnavailable, serve(h, PathHealth))
+	assert.Equal(t, http.StatusServiceUnavailable, serve(h, PathReadiness))
+	assert.Equal(t, http.StatusOK, serve(h, PathLiveness))
+}
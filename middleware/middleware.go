@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"context"
+
+	"github.com/MouseHatGames/mice/transport"
+)
+
+// Request holds the information about a request that is being handled by the server
+type Request struct {
+	// Path is the path of the endpoint that was requested, in the "handler.method" format
+	Path string
+
+	// Message is the transport message that the request was received in
+	Message *transport.Message
+
+	// Body is the decoded request data, which is a pointer to the endpoint's input type
+	Body interface{}
+}
+
+// HandlerFunc handles a decoded request, returning the response data that will be encoded and sent back to the caller
+type HandlerFunc func(ctx context.Context, req *Request) (interface{}, error)
+
+// ServerMiddleware wraps a HandlerFunc in order to run code before and after a request is handled
+type ServerMiddleware func(next HandlerFunc) HandlerFunc
+
+// ChainServer wraps h with all of the middlewares, the first one being the outermost
+func ChainServer(h HandlerFunc, mws ...ServerMiddleware) HandlerFunc {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+// Call holds the information about an outgoing call made by the client
+type Call struct {
+	// Service is the name of the service being called
+	Service string
+
+	// Path is the path of the endpoint being called, in the "handler.method" format
+	Path string
+
+	// Request is the data that will be encoded and sent to the service
+	Request interface{}
+
+	// Response is the value that the response data will be decoded into
+	Response interface{}
+
+	// Headers are added to the request message before it's sent
+	Headers transport.MessageHeaders
+}
+
+// CallFunc performs an outgoing call
+type CallFunc func(ctx context.Context, call *Call) error
+
+// ClientMiddleware wraps a CallFunc in order to run code before and after a call is made
+type ClientMiddleware func(next CallFunc) CallFunc
+
+// ChainClient wraps fn with all of the middlewares, the first one being the outermost
+func ChainClient(fn CallFunc, mws ...ClientMiddleware) CallFunc {
+	for i := len(mws) - 1; i >= 0; i-- {
+		fn = mws[i](fn)
+	}
+	return fn
+}
@@ -1,11 +1,19 @@
 package options
 
 import (
+	"context"
+	"sort"
+	"time"
+
 	"github.com/MouseHatGames/mice/broker"
+	"github.com/MouseHatGames/mice/client/breaker"
+	"github.com/MouseHatGames/mice/client/retry"
 	"github.com/MouseHatGames/mice/codec"
 	"github.com/MouseHatGames/mice/config"
 	"github.com/MouseHatGames/mice/discovery"
+	"github.com/MouseHatGames/mice/health"
 	"github.com/MouseHatGames/mice/logger"
+	"github.com/MouseHatGames/mice/middleware"
 	"github.com/MouseHatGames/mice/transport"
 	"go.opentelemetry.io/otel/trace"
 )
@@ -16,6 +24,22 @@ type Options struct {
 	RPCPort     int16
 	Environment Environment
 
+	ShutdownTimeout time.Duration
+
+	// ReadinessDrainDelay is the time that the service keeps accepting requests after reporting that it's not ready
+	// when it stops, so that it can be taken out of load balancing before its listener is closed
+	ReadinessDrainDelay time.Duration
+
+	// CallTimeout is the default maximum time to wait for outgoing calls to complete. No timeout is applied if it's 0
+	CallTimeout time.Duration
+
+	// RetryPolicy is the default policy used to retry failed calls. Calls are never retried if it's nil
+	RetryPolicy *retry.Policy
+
+	// CircuitBreaker configures the circuit breakers kept by the client for every called service.
+	// Circuit breaking is disabled if it's nil
+	CircuitBreaker *breaker.Settings
+
 	Logger    logger.Logger
 	Codec     codec.Codec
 	Transport transport.Transport
@@ -23,11 +47,110 @@ type Options struct {
 	Config    config.Config
 	Discovery discovery.Discovery
 	Tracer    trace.Tracer
+	Health    health.Health
+
+	// Codecs holds all codecs that can be used to decode incoming messages by their content type, see SetCodec
+	Codecs map[string]codec.Codec
+
+	// HealthPort is the port in which the health endpoints will be served on, in addition to the transport's listener.
+	// If it's 0 they will only be served by the transport, if it supports it.
+	HealthPort int16
+
+	// SocketConcurrency is the maximum number of requests received through a single socket that are handled at once.
+	// DefaultSocketConcurrency is used if it's 0
+	SocketConcurrency int
+
+	// MaxConcurrentRequests is the maximum number of requests that are handled at once by the whole server.
+	// There is no limit if it's 0
+	MaxConcurrentRequests int
+
+	// MaxQueuedRequests is the maximum number of requests that can wait for others to finish once MaxConcurrentRequests
+	// is reached. Requests that don't fit in the queue are rejected
+	MaxQueuedRequests int
+
+	// QueueTimeout is the maximum time that a request waits in the queue before being rejected.
+	// DefaultQueueTimeout is used if it's 0
+	QueueTimeout time.Duration
+
+	// RepanicInDevelopment makes panics in request handlers crash the service when running on the development environment,
+	// instead of being recovered from and returned as internal server errors
+	RepanicInDevelopment bool
+
+	ServerMiddlewares []middleware.ServerMiddleware
+	ClientMiddlewares []middleware.ClientMiddleware
+
+	BeforeStart []func() error
+	AfterStart  []func() error
+	BeforeStop  []func() error
+	AfterStop   []func() error
 }
 
+// DefaultReadinessDrainDelay is the time that the service waits between reporting that it's not ready and closing its
+// listener when it stops, which gives Kubernetes time to probe readiness and stop routing requests to it
+const DefaultReadinessDrainDelay = 5 * time.Second
+
 // DefaultRPCPort is the port that will be used for RPC connections if no other is specified
 const DefaultRPCPort = 7070
 
+// DefaultShutdownTimeout is the time that in-flight requests are given to finish when the service stops after
+// receiving a termination signal. It is slightly lower than Kubernetes' default termination grace period.
+const DefaultShutdownTimeout = 25 * time.Second
+
+// DefaultSocketConcurrency is the number of requests from a single socket that are handled at once if no other is specified
+const DefaultSocketConcurrency = 64
+
+// DefaultQueueTimeout is the maximum time that requests wait for a slot once MaxConcurrentRequests is reached if no
+// other is specified
+const DefaultQueueTimeout = 5 * time.Second
+
+// SetCodec sets the codec used to encode outgoing messages and registers it in Codecs, so that incoming messages
+// encoded with it are accepted. Codecs that were set before are still accepted, which allows migrating to a new codec
+// gradually: services that don't support the new one yet will reply with the ones they accept.
+// Codecs that don't implement codec.ContentTyper aren't registered, and messages encoded with them have no content type.
+func (o *Options) SetCodec(c codec.Codec) {
+	if o.Codecs == nil {
+		o.Codecs = make(map[string]codec.Codec)
+	}
+
+	o.Codec = c
+	if ct := codec.ContentType(c); ct != "" {
+		o.Codecs[ct] = c
+	}
+}
+
+// GetCodec returns the codec for a content type, which is either the service's codec or one of the registered ones
+func (o *Options) GetCodec(contentType string) (codec.Codec, bool) {
+	if o.Codec != nil && contentType != "" && codec.ContentType(o.Codec) == contentType {
+		return o.Codec, true
+	}
+
+	c, ok := o.Codecs[contentType]
+	return c, ok
+}
+
+// ContentTypes returns the content types of all codecs that can be used, starting with the service's codec
+func (o *Options) ContentTypes() []string {
+	types := make([]string, 0, len(o.Codecs)+1)
+
+	var own string
+	if o.Codec != nil {
+		own = codec.ContentType(o.Codec)
+	}
+	if own != "" {
+		types = append(types, own)
+	}
+
+	others := make([]string, 0, len(o.Codecs))
+	for ct := range o.Codecs {
+		if ct != own {
+			others = append(others, ct)
+		}
+	}
+	sort.Strings(others)
+
+	return append(types, others...)
+}
+
 // Option represents a function that can be used to mutate an Options object
 type Option func(*Options)
 
@@ -46,6 +169,46 @@ func RPCPort(port int16) Option {
 	}
 }
 
+// ShutdownTimeout sets the maximum time to wait for in-flight requests to finish when the service is stopped by a signal.
+// Defaults to DefaultShutdownTimeout
+func ShutdownTimeout(d time.Duration) Option {
+	return func(o *Options) {
+		o.ShutdownTimeout = d
+	}
+}
+
+// ReadinessDrainDelay sets the time that the service keeps accepting requests after reporting that it's not ready when
+// it stops, which should be longer than the period of the readiness probe. It counts towards the shutdown timeout.
+// Defaults to DefaultReadinessDrainDelay, and setting it to 0 closes the listener right away
+func ReadinessDrainDelay(d time.Duration) Option {
+	return func(o *Options) {
+		o.ReadinessDrainDelay = d
+	}
+}
+
+// CallTimeout sets the default maximum time to wait for outgoing calls to complete, which can be overridden on a
+// per-call basis with client.Timeout
+func CallTimeout(d time.Duration) Option {
+	return func(o *Options) {
+		o.CallTimeout = d
+	}
+}
+
+// RetryPolicy sets the default policy used to retry failed calls, which can be overridden on a per-call basis with client.Retry
+func RetryPolicy(p *retry.Policy) Option {
+	return func(o *Options) {
+		o.RetryPolicy = p
+	}
+}
+
+// CircuitBreaker enables circuit breaking on outgoing calls, which makes the client fail fast with client.ErrCircuitOpen
+// when calling a service that has been failing repeatedly
+func CircuitBreaker(s *breaker.Settings) Option {
+	return func(o *Options) {
+		o.CircuitBreaker = s
+	}
+}
+
 // Logger sets the logger that will receive the log messages sent by the library
 func Logger(l logger.Logger) Option {
 	return func(o *Options) {
@@ -92,3 +255,105 @@ func Tracer(tracer trace.Tracer) Option {
 		o.Tracer = tracer
 	}
 }
+
+// BeforeStart adds a function that will be called when the service starts, before any component is started.
+// If it returns an error the service won't start.
+func BeforeStart(fn func() error) Option {
+	return func(o *Options) {
+		o.BeforeStart = append(o.BeforeStart, fn)
+	}
+}
+
+// AfterStart adds a function that will be called once the service is listening for requests.
+// If it returns an error the service will be stopped.
+func AfterStart(fn func() error) Option {
+	return func(o *Options) {
+		o.AfterStart = append(o.AfterStart, fn)
+	}
+}
+
+// BeforeStop adds a function that will be called when the service is stopping, before it stops accepting requests
+func BeforeStop(fn func() error) Option {
+	return func(o *Options) {
+		o.BeforeStop = append(o.BeforeStop, fn)
+	}
+}
+
+// AfterStop adds a function that will be called after the service has stopped and all components have been closed
+func AfterStop(fn func() error) Option {
+	return func(o *Options) {
+		o.AfterStop = append(o.AfterStop, fn)
+	}
+}
+
+// HealthCheck registers a check that will be run when the service's health or readiness is requested
+func HealthCheck(name string, check func(ctx context.Context) error) Option {
+	return func(o *Options) {
+		o.Health.Register(name, check)
+	}
+}
+
+// SocketConcurrency sets the maximum number of requests received through a single socket that are handled at once.
+// Once it's reached, no more messages are read from the socket until a request finishes. Setting it to 1 makes
+// requests from the same socket be handled sequentially. Defaults to DefaultSocketConcurrency
+func SocketConcurrency(n int) Option {
+	return func(o *Options) {
+		o.SocketConcurrency = n
+	}
+}
+
+// MaxConcurrentRequests sets the maximum number of requests that are handled at once by the server. Once it's reached,
+// requests wait in a queue of up to MaxQueuedRequests, and the ones that don't fit are rejected with a 503 error that
+// hints the caller to retry later. Streams aren't limited
+func MaxConcurrentRequests(n int) Option {
+	return func(o *Options) {
+		o.MaxConcurrentRequests = n
+	}
+}
+
+// MaxQueuedRequests sets the maximum number of requests that can wait for a slot once MaxConcurrentRequests is reached.
+// Defaults to 0, which means that requests are rejected as soon as the limit is reached
+func MaxQueuedRequests(n int) Option {
+	return func(o *Options) {
+		o.MaxQueuedRequests = n
+	}
+}
+
+// QueueTimeout sets the maximum time that a request waits in the queue for a slot before being rejected, which is
+// lowered to the caller's timeout if it has one. Defaults to DefaultQueueTimeout
+func QueueTimeout(d time.Duration) Option {
+	return func(o *Options) {
+		o.QueueTimeout = d
+	}
+}
+
+// HealthPort sets a separate port in which the /healthz, /readyz and /livez endpoints will be served on
+func HealthPort(port int16) Option {
+	return func(o *Options) {
+		o.HealthPort = port
+	}
+}
+
+// ServerMiddleware adds one or more middlewares that will wrap every request handled by the server.
+// The first middleware will be the outermost one.
+func ServerMiddleware(mws ...middleware.ServerMiddleware) Option {
+	return func(o *Options) {
+		o.ServerMiddlewares = append(o.ServerMiddlewares, mws...)
+	}
+}
+
+// ClientMiddleware adds one or more middlewares that will wrap every call made by the client.
+// The first middleware will be the outermost one.
+func ClientMiddleware(mws ...middleware.ClientMiddleware) Option {
+	return func(o *Options) {
+		o.ClientMiddlewares = append(o.ClientMiddlewares, mws...)
+	}
+}
+
+// RepanicInDevelopment makes panics that occur inside request handlers crash the service if running under the development
+// environment, which makes debugging easier. On any other environment they are always recovered from.
+func RepanicInDevelopment() Option {
+	return func(o *Options) {
+		o.RepanicInDevelopment = true
+	}
+}
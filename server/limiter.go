@@ -0,0 +1,61 @@
+package server
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/MouseHatGames/mice/errors"
+)
+
+// overloadRetryAfter is the time that callers are asked to wait before retrying a request that was rejected
+// because the server was overloaded
+const overloadRetryAfter = time.Second
+
+// limiter limits the number of requests that are handled at once, letting a limited number of them wait for a slot
+type limiter struct {
+	slots     chan struct{}
+	queued    int32
+	maxQueued int32
+}
+
+func newLimiter(maxConcurrent, maxQueued int) *limiter {
+	return &limiter{
+		slots:     make(chan struct{}, maxConcurrent),
+		maxQueued: int32(maxQueued),
+	}
+}
+
+// admit is called for every request before a goroutine is started to handle it. It returns true if the request got
+// a slot, false if it got a place in the queue and must call wait, or a 503 error if the queue is full.
+func (l *limiter) admit() (bool, error) {
+	select {
+	case l.slots <- struct{}{}:
+		return true, nil
+	default:
+	}
+
+	if atomic.AddInt32(&l.queued, 1) > l.maxQueued {
+		atomic.AddInt32(&l.queued, -1)
+		return false, errors.ServiceUnavailable(overloadRetryAfter, "server is overloaded")
+	}
+
+	return false, nil
+}
+
+// wait waits in the queue for a slot, leaving the queue once it gets one or ctx is done, in which case it returns a
+// 503 error
+func (l *limiter) wait(ctx context.Context) error {
+	defer atomic.AddInt32(&l.queued, -1)
+
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return errors.ServiceUnavailable(overloadRetryAfter, "server is overloaded")
+	}
+}
+
+func (l *limiter) release() {
+	<-l.slots
+}
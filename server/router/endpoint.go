@@ -7,4 +7,7 @@ type endpoint struct {
 	HandlerFunc reflect.Value
 	In          reflect.Type
 	Out         reflect.Type
+
+	// Stream is true if the endpoint takes a Stream instead of a request and a response
+	Stream bool
 }
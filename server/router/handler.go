@@ -43,6 +43,15 @@ func getEndpoint(m reflect.Method) *endpoint {
 		return nil
 	}
 
+	// Streaming functions must have inputs like (c context.Context, stream router.Stream), plus one input for the receiver
+	if m.Type.NumIn() == 3 && m.Type.In(2) == streamType && m.Type.NumOut() == 1 {
+		return &endpoint{
+			Name:        m.Name,
+			HandlerFunc: m.Func,
+			Stream:      true,
+		}
+	}
+
 	// Functions must have inputs like (c context.Context, req *data.Request, resp *data.Response), plus one input for the receiver
 	if m.Type.NumIn() != 4 {
 		return nil
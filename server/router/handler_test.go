@@ -12,8 +12,8 @@ type dummy struct{}
 type dummyin struct{}
 type dummyout struct{}
 
-func (*dummy) Test(ctx context.Context, data *dummyin) (*dummyout, error) {
-	return nil, nil
+func (*dummy) Test(ctx context.Context, data *dummyin, resp *dummyout) error {
+	return nil
 }
 
 func TestGetEndpoint(t *testing.T) {
@@ -24,6 +24,23 @@ func TestGetEndpoint(t *testing.T) {
 
 	assert.NotNil(t, ep)
 	assert.Equal(t, "Test", ep.Name)
-	assert.Equal(t, reflect.TypeOf(&dummyin{}), ep.In)
-	assert.Equal(t, reflect.TypeOf(&dummyout{}), ep.Out)
+	assert.Equal(t, reflect.TypeOf(dummyin{}), ep.In)
+	assert.Equal(t, reflect.TypeOf(dummyout{}), ep.Out)
+}
+
+type streamer struct{}
+
+func (*streamer) Test(ctx context.Context, stream Stream) error {
+	return nil
+}
+
+func TestGetStreamEndpoint(t *testing.T) {
+	d := &streamer{}
+	m := reflect.ValueOf(d).Type().Method(0)
+
+	ep := getEndpoint(m)
+
+	assert.NotNil(t, ep)
+	assert.Equal(t, "Test", ep.Name)
+	assert.True(t, ep.Stream)
 }
@@ -0,0 +1,35 @@
+package router
+
+import (
+	"github.com/MouseHatGames/mice/codec"
+	"github.com/MouseHatGames/mice/errors"
+	"github.com/MouseHatGames/mice/options"
+	"github.com/MouseHatGames/mice/transport"
+)
+
+// RequestCodec returns the codec that the data of a message has been encoded with, according to its content type.
+// Messages without a content type are assumed to be encoded with the service's codec.
+func RequestCodec(opts *options.Options, msg *transport.Message) (codec.Codec, error) {
+	ct, ok := msg.GetContentType()
+	if !ok {
+		return opts.Codec, nil
+	}
+
+	if c, ok := opts.GetCodec(ct); ok {
+		return c, nil
+	}
+
+	return nil, errors.NewError(415, "unsupported content type %q", ct)
+}
+
+// ResponseCodec returns the codec that the response to a message should be encoded with, which is the first one in
+// the message's accept header that the service supports or, if there are none, the one the message was encoded with
+func ResponseCodec(opts *options.Options, msg *transport.Message, reqCodec codec.Codec) codec.Codec {
+	for _, ct := range msg.GetAccept() {
+		if c, ok := opts.GetCodec(ct); ok {
+			return c
+		}
+	}
+
+	return reqCodec
+}
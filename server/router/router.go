@@ -2,13 +2,16 @@ package router
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"reflect"
+	"runtime/debug"
 	"strings"
 
 	"github.com/MouseHatGames/mice/auth"
+	"github.com/MouseHatGames/mice/codec"
+	"github.com/MouseHatGames/mice/errors"
 	"github.com/MouseHatGames/mice/logger"
+	"github.com/MouseHatGames/mice/middleware"
 	"github.com/MouseHatGames/mice/options"
 	"github.com/MouseHatGames/mice/tracing"
 	"github.com/MouseHatGames/mice/transport"
@@ -17,12 +20,19 @@ import (
 	"go.opentelemetry.io/otel/trace"
 )
 
-var ErrMalformedPath = errors.New("malformed request path")
-var ErrEndpointNotFound = errors.New("endpoint not found")
+var ErrMalformedPath = errors.BadRequest("malformed request path")
+var ErrEndpointNotFound = errors.NotFound("endpoint not found")
+var ErrStreamEndpoint = errors.BadRequest("endpoint must be called as a stream")
+var ErrNotStreamEndpoint = errors.BadRequest("endpoint can't be called as a stream")
 
 type Router interface {
 	AddHandler(h interface{}, name string, methods []string)
-	Handle(path string, req *transport.Message) ([]byte, error)
+
+	// Handle calls the endpoint that path points to with the request in req, writing the response's data to resp
+	Handle(ctx context.Context, path string, req *transport.Message, resp *transport.Message) error
+
+	// HandleStream runs a streaming endpoint until it returns. req is the message that opened the stream.
+	HandleStream(ctx context.Context, path string, req *transport.Message, stream Stream) error
 }
 
 type router struct {
@@ -46,6 +56,11 @@ func (s *router) AddHandler(h interface{}, name string, methods []string) {
 	}
 
 	hdl := newHandler(h, name, metmap)
+
+	if err := s.checkTypes(hdl); err != nil {
+		panic(err.Error())
+	}
+
 	s.handlers[hdl.Name] = hdl
 
 	for k := range hdl.Endpoints {
@@ -53,12 +68,135 @@ func (s *router) AddHandler(h interface{}, name string, methods []string) {
 	}
 }
 
-func (s *router) Handle(path string, req *transport.Message) ([]byte, error) {
+// checkTypes makes sure that the codec can handle the requests and responses of a handler's endpoints
+func (s *router) checkTypes(hdl *handler) error {
+	checker, ok := s.opts.Codec.(codec.TypeChecker)
+	if !ok {
+		return nil
+	}
+
+	for _, ep := range hdl.Endpoints {
+		if ep.Stream {
+			continue
+		}
+
+		// Endpoints are always called with pointers to their request and response
+		if err := checker.CheckType(reflect.PtrTo(ep.In)); err != nil {
+			return fmt.Errorf("request of %s.%s: %w", hdl.Name, ep.Name, err)
+		}
+		if err := checker.CheckType(reflect.PtrTo(ep.Out)); err != nil {
+			return fmt.Errorf("response of %s.%s: %w", hdl.Name, ep.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func (s *router) Handle(ctx context.Context, path string, req *transport.Message, resp *transport.Message) error {
 	s.log.Debugf("request to %s", path)
 
+	handler, method, err := s.find(path)
+	if err != nil {
+		return err
+	}
+	if method.Stream {
+		return ErrStreamEndpoint
+	}
+
+	reqCodec, err := RequestCodec(s.opts, req)
+	if err != nil {
+		// Let the caller know which content types it can use instead
+		resp.SetAccept(s.opts.ContentTypes())
+		return err
+	}
+
+	in, err := s.decode(reqCodec, method.In, req.Data)
+	if err != nil {
+		return errors.BadRequest("decode request: %s", err)
+	}
+
+	ctx, cancel := s.requestContext(ctx, req)
+	defer cancel()
+
+	ctx, span := s.opts.Tracer.Start(ctx, path, trace.WithAttributes(
+		attribute.Int("request_length", len(req.Data)),
+		attribute.Bool("authed", auth.IsAuthed(ctx)),
+	))
+	defer span.End()
+
+	call := middleware.ChainServer(func(ctx context.Context, r *middleware.Request) (interface{}, error) {
+		return s.callHandler(ctx, r, handler, method)
+	}, s.opts.ServerMiddlewares...)
+
+	out, err := call(ctx, &middleware.Request{
+		Path:    path,
+		Message: req,
+		Body:    in.Interface(),
+	})
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "request handler failed")
+
+		return err
+	}
+
+	respCodec := ResponseCodec(s.opts, req, reqCodec)
+
+	outdata, err := respCodec.Marshal(out)
+	if err != nil {
+		return fmt.Errorf("encode response: %w", err)
+	}
+
+	span.SetAttributes(attribute.Int("response_length", len(outdata)))
+
+	resp.Data = outdata
+	resp.SetContentType(codec.ContentType(respCodec))
+
+	return nil
+}
+
+func (s *router) HandleStream(ctx context.Context, path string, req *transport.Message, stream Stream) error {
+	s.log.Debugf("stream to %s", path)
+
+	handler, method, err := s.find(path)
+	if err != nil {
+		return err
+	}
+	if !method.Stream {
+		return ErrNotStreamEndpoint
+	}
+
+	ctx, cancel := s.requestContext(ctx, req)
+	defer cancel()
+
+	ctx, span := s.opts.Tracer.Start(ctx, path, trace.WithAttributes(
+		attribute.Bool("stream", true),
+		attribute.Bool("authed", auth.IsAuthed(ctx)),
+	))
+	defer span.End()
+
+	call := middleware.ChainServer(func(ctx context.Context, r *middleware.Request) (interface{}, error) {
+		return s.callHandler(ctx, r, handler, method)
+	}, s.opts.ServerMiddlewares...)
+
+	_, err = call(ctx, &middleware.Request{
+		Path:    path,
+		Message: req,
+		Body:    &contextStream{stream, ctx},
+	})
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "stream handler failed")
+	}
+
+	return err
+}
+
+// find returns the handler and endpoint that a path in the "handler.method" format points to
+func (s *router) find(path string) (*handler, *endpoint, error) {
 	dotidx := strings.IndexRune(path, '.')
 	if dotidx == -1 {
-		return nil, ErrMalformedPath
+		return nil, nil, ErrMalformedPath
 	}
 
 	hndname := path[:dotidx]
@@ -66,65 +204,92 @@ func (s *router) Handle(path string, req *transport.Message) ([]byte, error) {
 
 	handler, ok := s.handlers[hndname]
 	if !ok {
-		return nil, ErrEndpointNotFound
+		return nil, nil, ErrEndpointNotFound
 	}
 
 	method, ok := handler.Endpoints[metname]
 	if !ok {
-		return nil, ErrEndpointNotFound
+		return nil, nil, ErrEndpointNotFound
 	}
 
-	in, err := s.decode(method.In, req.Data)
-	if err != nil {
-		return nil, fmt.Errorf("decode request: %w", err)
-	}
+	return handler, method, nil
+}
 
-	respValue := reflect.New(method.Out)
+// requestContext returns the context in which a request will be handled, which carries the request itself,
+// its tracing information and the authenticated user
+func (s *router) requestContext(ctx context.Context, req *transport.Message) (context.Context, context.CancelFunc) {
+	cancel := func() {}
 
-	ctx := transport.ContextWithRequest(context.Background(), req)
+	// Honor the caller's deadline so that work is abandoned once nobody is waiting for the response
+	if timeout, ok := req.GetTimeout(); ok {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	}
+
+	ctx = transport.ContextWithRequest(ctx, req)
 	ctx = tracing.ExtractFromMessage(ctx, req)
 
 	if id, ok := req.GetUserID(); ok {
 		ctx = auth.WithUserID(ctx, id)
 	}
 
-	ctx, span := s.opts.Tracer.Start(ctx, path, trace.WithAttributes(
-		attribute.Int("request_length", len(req.Data)),
-		attribute.Bool("authed", auth.IsAuthed(ctx)),
-	))
-	defer span.End()
+	return ctx, cancel
+}
+
+// callHandler calls an endpoint's handler function, recovering from any panic that occurs inside of it
+func (s *router) callHandler(ctx context.Context, r *middleware.Request, handler *handler, method *endpoint) (resp interface{}, err error) {
+	defer func() {
+		v := recover()
+		if v == nil {
+			return
+		}
+
+		if s.opts.RepanicInDevelopment && s.opts.Environment.IsDevelopment() {
+			panic(v)
+		}
+
+		s.log.Errorf("panic while handling %s: %v\n%s", r.Path, v, debug.Stack())
+
+		span := trace.SpanFromContext(ctx)
+		span.RecordError(fmt.Errorf("panic: %v", v), trace.WithStackTrace(true))
+
+		resp = nil
+		err = errors.InternalServerError("internal server error")
+	}()
+
+	if method.Stream {
+		ret := method.HandlerFunc.Call([]reflect.Value{
+			reflect.ValueOf(handler.Instance),
+			reflect.ValueOf(ctx),
+			reflect.ValueOf(r.Body),
+		})
+
+		if !ret[0].IsNil() {
+			return nil, ret[0].Interface().(error)
+		}
+		return nil, nil
+	}
+
+	respValue := reflect.New(method.Out)
 
 	ret := method.HandlerFunc.Call([]reflect.Value{
 		reflect.ValueOf(handler.Instance),
 		reflect.ValueOf(ctx),
-		in,
+		reflect.ValueOf(r.Body),
 		respValue,
 	})
 
 	if !ret[0].IsNil() {
-		err := ret[0].Interface().(error)
-
-		span.RecordError(err)
-		span.SetStatus(codes.Error, "request handler failed")
-
-		return nil, err
-	}
-
-	outdata, err := s.opts.Codec.Marshal(respValue.Interface())
-	if err != nil {
-		return nil, fmt.Errorf("encode response: %w", err)
+		return nil, ret[0].Interface().(error)
 	}
 
-	span.SetAttributes(attribute.Int("response_length", len(outdata)))
-
-	return outdata, nil
+	return respValue.Interface(), nil
 }
 
-func (s *router) decode(t reflect.Type, d []byte) (reflect.Value, error) {
+func (s *router) decode(c codec.Codec, t reflect.Type, d []byte) (reflect.Value, error) {
 	val := reflect.New(t)
 	intf := val.Interface()
 
-	if err := s.opts.Codec.Unmarshal(d, intf); err != nil {
+	if err := c.Unmarshal(d, intf); err != nil {
 		return reflect.Value{}, err
 	}
 
@@ -1,10 +1,20 @@
 package router
 
 import (
+	"context"
 	"reflect"
 	"testing"
+	"time"
 
+	"github.com/MouseHatGames/mice/codec/json"
+	"github.com/MouseHatGames/mice/codec/msgpack"
+	"github.com/MouseHatGames/mice/codec/proto"
+	"github.com/MouseHatGames/mice/errors"
+	"github.com/MouseHatGames/mice/logger/stdout"
+	"github.com/MouseHatGames/mice/middleware"
 	"github.com/MouseHatGames/mice/options"
+	"github.com/MouseHatGames/mice/tracing"
+	"github.com/MouseHatGames/mice/transport"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -26,9 +36,202 @@ func TestDecode(t *testing.T) {
 		opts: &options.Options{Codec: c},
 	}
 
-	ret, err := s.decode(reflect.TypeOf(&dummy{}), []byte{})
+	ret, err := s.decode(c, reflect.TypeOf(dummy{}), []byte{})
 
 	assert.Nil(t, err)
 	assert.NotNil(t, ret)
-	assert.IsType(t, &dummy{}, ret)
+	assert.IsType(t, &dummy{}, ret.Interface())
+	assert.Equal(t, ret.Interface(), c.out)
+}
+
+func TestHandleMiddleware(t *testing.T) {
+	var calls []string
+
+	mw := func(name string) middleware.ServerMiddleware {
+		return func(next middleware.HandlerFunc) middleware.HandlerFunc {
+			return func(ctx context.Context, req *middleware.Request) (interface{}, error) {
+				assert.Equal(t, "dummy.Test", req.Path)
+				assert.IsType(t, &dummyin{}, req.Body)
+
+				calls = append(calls, name)
+				return next(ctx, req)
+			}
+		}
+	}
+
+	s := NewRouter(&options.Options{
+		Codec:             &mockCodec{},
+		Logger:            stdout.NewStdoutLogger(" "),
+		Tracer:            tracing.NoopTracer(),
+		ServerMiddlewares: []middleware.ServerMiddleware{mw("a"), mw("b")},
+	})
+	s.AddHandler(&dummy{}, "dummy", []string{"Test"})
+
+	err := s.Handle(context.Background(), "dummy.Test", transport.NewMessage(), transport.NewMessage())
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a", "b"}, calls)
+}
+
+func TestHandleMiddlewareShortCircuit(t *testing.T) {
+	denied := errors.Forbidden("denied")
+
+	s := NewRouter(&options.Options{
+		Codec:  &mockCodec{},
+		Logger: stdout.NewStdoutLogger(" "),
+		Tracer: tracing.NoopTracer(),
+		ServerMiddlewares: []middleware.ServerMiddleware{
+			func(next middleware.HandlerFunc) middleware.HandlerFunc {
+				return func(ctx context.Context, req *middleware.Request) (interface{}, error) {
+					return nil, denied
+				}
+			},
+		},
+	})
+	s.AddHandler(&dummy{}, "dummy", []string{"Test"})
+
+	err := s.Handle(context.Background(), "dummy.Test", transport.NewMessage(), transport.NewMessage())
+
+	assert.Equal(t, denied, err)
+}
+
+type panicky struct{}
+
+func (*panicky) Test(ctx context.Context, data *dummyin, resp *dummyout) error {
+	panic("oops")
+}
+
+func TestHandlePanic(t *testing.T) {
+	opts := &options.Options{
+		Codec:                &mockCodec{},
+		Logger:               stdout.NewStdoutLogger(" "),
+		Tracer:               tracing.NoopTracer(),
+		Environment:          options.EnvironmentProduction,
+		RepanicInDevelopment: true,
+	}
+
+	s := NewRouter(opts)
+	s.AddHandler(&panicky{}, "panicky", []string{"Test"})
+
+	err := s.Handle(context.Background(), "panicky.Test", transport.NewMessage(), transport.NewMessage())
+
+	if assert.IsType(t, &errors.Error{}, err) {
+		assert.EqualValues(t, 500, err.(*errors.Error).StatusCode)
+	}
+
+	opts.Environment = options.EnvironmentDevelopment
+
+	assert.Panics(t, func() {
+		s.Handle(context.Background(), "panicky.Test", transport.NewMessage(), transport.NewMessage())
+	})
+}
+
+type deadliner struct {
+	deadline time.Time
+	ok       bool
+}
+
+func (d *deadliner) Test(ctx context.Context, data *dummyin, resp *dummyout) error {
+	d.deadline, d.ok = ctx.Deadline()
+	return nil
+}
+
+func TestHandleTimeout(t *testing.T) {
+	s := NewRouter(&options.Options{
+		Codec:  &mockCodec{},
+		Logger: stdout.NewStdoutLogger(" "),
+		Tracer: tracing.NoopTracer(),
+	})
+
+	d := &deadliner{}
+	s.AddHandler(d, "deadliner", []string{"Test"})
+
+	req := transport.NewMessage()
+	req.SetTimeout(time.Minute)
+
+	err := s.Handle(context.Background(), "deadliner.Test", req, transport.NewMessage())
+
+	assert.Nil(t, err)
+	assert.True(t, d.ok)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), d.deadline, time.Second)
+}
+
+func TestAddHandlerCheckTypes(t *testing.T) {
+	o := &options.Options{Logger: stdout.NewStdoutLogger(" ")}
+	proto.Codec()(o)
+
+	s := NewRouter(o)
+
+	assert.Panics(t, func() {
+		s.AddHandler(&dummy{}, "dummy", []string{"Test"})
+	})
+	assert.NotPanics(t, func() {
+		s.AddHandler(&streamer{}, "streamer", []string{"Test"})
+	})
+}
+
+func TestHandleNegotiation(t *testing.T) {
+	o := &options.Options{
+		Logger: stdout.NewStdoutLogger(" "),
+		Tracer: tracing.NoopTracer(),
+	}
+	json.Codec()(o)
+	msgpack.Codec()(o)
+
+	s := NewRouter(o)
+	s.AddHandler(&dummy{}, "dummy", []string{"Test"})
+
+	handle := func(contentType string, accept ...string) (*transport.Message, error) {
+		req := transport.NewMessage()
+		req.SetContentType(contentType)
+		req.SetAccept(accept)
+		req.Data = []byte("{}")
+
+		resp := transport.NewMessage()
+		err := s.Handle(context.Background(), "dummy.Test", req, resp)
+		return resp, err
+	}
+
+	t.Run("same codec", func(t *testing.T) {
+		resp, err := handle("application/json")
+		assert.Nil(t, err)
+
+		ct, _ := resp.GetContentType()
+		assert.Equal(t, "application/json", ct)
+	})
+	t.Run("accept", func(t *testing.T) {
+		resp, err := handle("application/json", "application/xml", "application/msgpack")
+		assert.Nil(t, err)
+
+		ct, _ := resp.GetContentType()
+		assert.Equal(t, "application/msgpack", ct)
+	})
+	t.Run("unsupported", func(t *testing.T) {
+		resp, err := handle("application/xml")
+
+		if assert.IsType(t, &errors.Error{}, err) {
+			assert.EqualValues(t, 415, err.(*errors.Error).StatusCode)
+		}
+		assert.Equal(t, []string{"application/msgpack", "application/json"}, resp.GetAccept())
+	})
+}
+
+func TestHandleWithoutContentType(t *testing.T) {
+	o := &options.Options{
+		Logger: stdout.NewStdoutLogger(" "),
+		Tracer: tracing.NoopTracer(),
+	}
+	o.SetCodec(&mockCodec{})
+
+	s := NewRouter(o)
+	s.AddHandler(&dummy{}, "dummy", []string{"Test"})
+
+	assert.Empty(t, o.ContentTypes(), "codecs without a content type aren't advertised")
+
+	req := transport.NewMessage()
+	resp := transport.NewMessage()
+	assert.Nil(t, s.Handle(context.Background(), "dummy.Test", req, resp))
+
+	_, ok := resp.GetContentType()
+	assert.False(t, ok, "the response has no content type")
 }
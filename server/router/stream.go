@@ -0,0 +1,35 @@
+package router
+
+import (
+	"context"
+	"reflect"
+)
+
+// Stream is a bidirectional stream of messages between a client and a streaming endpoint.
+//
+// Streaming endpoints are methods in the form of (ctx context.Context, stream Stream) error. The stream is closed
+// once the method returns, and the returned error is sent to the client.
+type Stream interface {
+	// Context returns the context of the stream, which is done once the client goes away
+	Context() context.Context
+
+	// Send encodes a message and sends it to the client. If the client has transport.StreamWindow messages that it
+	// hasn't read yet, Send waits until it reads them or the stream's context is done.
+	Send(msg interface{}) error
+
+	// Receive waits for a message from the client and decodes it into msg, which must be a pointer.
+	// It returns io.EOF once the client has closed its side of the stream.
+	Receive(msg interface{}) error
+}
+
+var streamType = reflect.TypeOf((*Stream)(nil)).Elem()
+
+// contextStream overrides the context of a stream with the one the handler is called with
+type contextStream struct {
+	Stream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
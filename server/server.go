@@ -2,11 +2,14 @@ package server
 
 import (
 	"context"
-	"errors"
+	goerrors "errors"
 	"fmt"
 	"io"
+	"runtime/debug"
+	"sync"
 
 	"github.com/MouseHatGames/mice/broker"
+	"github.com/MouseHatGames/mice/errors"
 	"github.com/MouseHatGames/mice/logger"
 	"github.com/MouseHatGames/mice/options"
 	"github.com/MouseHatGames/mice/server/router"
@@ -14,7 +17,15 @@ import (
 )
 
 type Server interface {
+	// Start starts listening for requests in the background
 	Start() error
+
+	// Stop stops accepting requests and waits for the in-flight ones to finish, or until ctx is done
+	Stop(ctx context.Context) error
+
+	// Err returns a channel that receives an error if the server stops accepting requests unexpectedly
+	Err() <-chan error
+
 	AddHandler(h interface{}, name string, methods ...string)
 	Publish(ctx context.Context, topic string, data interface{}) error
 }
@@ -23,6 +34,16 @@ type server struct {
 	opts   *options.Options
 	log    logger.Logger
 	router router.Router
+
+	listener transport.Listener
+	limiter  *limiter
+	errc     chan error
+
+	// inflight tracks the sockets that have been accepted and not closed yet, which wait for their requests and
+	// streams before closing. Sockets are rejected once stopping is set, so that it doesn't grow while being waited for.
+	inflight   sync.WaitGroup
+	stopping   bool
+	stoppingMu sync.Mutex
 }
 
 func NewServer(opts *options.Options) Server {
@@ -30,39 +51,118 @@ func NewServer(opts *options.Options) Server {
 		opts:   opts,
 		log:    opts.Logger.GetLogger("server"),
 		router: router.NewRouter(opts),
+		errc:   make(chan error, 1),
 	}
 }
 
 func (s *server) Start() error {
 	ctx := context.Background()
 
+	if s.opts.MaxConcurrentRequests > 0 {
+		s.limiter = newLimiter(s.opts.MaxConcurrentRequests, s.opts.MaxQueuedRequests)
+	}
+
 	l, err := s.opts.Transport.Listen(ctx, fmt.Sprintf(":%d", s.opts.RPCPort))
 	if err != nil {
 		return err
 	}
+	s.listener = l
 
-	if err := l.Accept(ctx, s.handle); err != nil {
-		return fmt.Errorf("accept connections: %w", err)
-	}
+	go func() {
+		if err := l.Accept(ctx, s.handle); err != nil {
+			s.errc <- fmt.Errorf("accept connections: %w", err)
+		}
+	}()
 
 	return nil
 }
 
+func (s *server) Stop(ctx context.Context) error {
+	if s.listener == nil {
+		return nil
+	}
+
+	s.log.Debugf("closing listener")
+
+	// Some listeners wait for the requests they're receiving before returning, so that's bounded by ctx too
+	closed := make(chan error, 1)
+	go func() {
+		closed <- s.listener.Close()
+	}()
+
+	select {
+	case err := <-closed:
+		if err != nil {
+			return fmt.Errorf("close listener: %w", err)
+		}
+	case <-ctx.Done():
+		return fmt.Errorf("close listener: %w", ctx.Err())
+	}
+
+	s.stoppingMu.Lock()
+	s.stopping = true
+	s.stoppingMu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		s.inflight.Wait()
+		close(drained)
+	}()
+
+	s.log.Debugf("waiting for in-flight requests")
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("drain requests: %w", ctx.Err())
+	}
+}
+
+func (s *server) Err() <-chan error {
+	return s.errc
+}
+
 func (s *server) AddHandler(h interface{}, name string, methods ...string) {
 	s.router.AddHandler(h, name, methods)
 }
 
 func (s *server) handle(soc transport.Socket) {
+	// The socket is counted before anything is received from it, so that Stop waits for requests that are still
+	// being received
+	if !s.track() {
+		s.log.Debugf("rejecting socket accepted while stopping")
+		soc.Close()
+		return
+	}
+
 	go func() {
+		defer s.inflight.Done()
 		defer soc.Close()
 
-		var req transport.Message
 		ctx := context.Background()
+		streams := newSocketStreams(soc)
+		var requests sync.WaitGroup
+
+		// Once nothing else can be received from the client, let the handlers of open streams know and wait for them
+		// and for the requests that are still being handled before closing the socket, even if receiving panicked
+		defer func() {
+			streams.closeAll()
+			streams.handlers.Wait()
+			requests.Wait()
+		}()
+		defer s.recoverPanic("socket", nil)
+
+		// workers limits the number of requests from this socket that are handled at once
+		workers := make(chan struct{}, s.socketConcurrency())
 
 		for {
-			err := soc.Receive(ctx, &req)
+			// Messages are handled in the background, so a new one is needed each time
+			req := new(transport.Message)
+
+			err := soc.Receive(ctx, req)
 			if err != nil {
-				if !errors.Is(err, io.EOF) {
+				if !goerrors.Is(err, io.EOF) {
 					s.log.Errorf("receive message: %s", err)
 				} else {
 					s.log.Debugf("socket eof")
@@ -70,31 +170,162 @@ func (s *server) handle(soc transport.Socket) {
 				break
 			}
 
-			s.handleRequest(&req, soc)
+			if kind, ok := req.GetStream(); ok {
+				s.handleStreamMessage(kind, req, streams)
+				continue
+			}
+
+			workers <- struct{}{}
+
+			// The limiter is checked before starting a goroutine, so that they don't pile up when overloaded
+			queued := false
+			if s.limiter != nil {
+				gotSlot, err := s.limiter.admit()
+				if err != nil {
+					<-workers
+					s.reject(req, soc, err)
+					continue
+				}
+				queued = !gotSlot
+			}
+
+			requests.Add(1)
+
+			go func() {
+				defer func() { <-workers }()
+				defer requests.Done()
+
+				s.handleRequest(req, soc, queued)
+			}()
 		}
 	}()
 }
 
-func (s *server) handleRequest(req *transport.Message, soc transport.Socket) {
+// track adds a socket to inflight, unless the server is stopping
+func (s *server) track() bool {
+	s.stoppingMu.Lock()
+	defer s.stoppingMu.Unlock()
+
+	if s.stopping {
+		return false
+	}
+
+	s.inflight.Add(1)
+	return true
+}
+
+// socketConcurrency returns the maximum number of requests from a single socket that can be handled at once
+func (s *server) socketConcurrency() int {
+	if s.opts.SocketConcurrency > 0 {
+		return s.opts.SocketConcurrency
+	}
+	return options.DefaultSocketConcurrency
+}
+
+// handleRequest handles a request and sends the response through soc. Since requests from the same socket may be
+// handled concurrently, the response carries the request's ID so that the client can match them.
+// If the limiter is enabled, the request has either taken a slot already or, if queued is true, must wait for one.
+func (s *server) handleRequest(req *transport.Message, soc transport.Socket, queued bool) {
+	var resp transport.Message
+	resp.SetRequestID(req.MustGetRequestID())
+
+	if err := s.limitedHandle(req, &resp, queued); err != nil {
+		resp.SetError(err)
+	}
+
+	s.sendResponse(soc, &resp)
+}
+
+// sendResponse sends the response to a request. If the transport can't send it because of its contents, for example
+// because it's too large, an error is sent instead so that the client doesn't wait for it forever.
+func (s *server) sendResponse(soc transport.Socket, resp *transport.Message) {
+	err := soc.Send(context.Background(), resp)
+
+	var ierr *transport.InvalidMessageError
+	if goerrors.As(err, &ierr) {
+		s.log.Errorf("send response: %s", err)
+
+		fallback := transport.NewMessage()
+		fallback.SetRequestID(resp.MustGetRequestID())
+		fallback.SetError(errors.InternalServerError("response can't be sent: %s", ierr.Err))
+
+		err = soc.Send(context.Background(), fallback)
+	}
+
+	if err != nil {
+		s.log.Errorf("send response: %s", err)
+	}
+}
+
+// limitedHandle handles a request, waiting for a slot first if it's queued and releasing it once it's done
+func (s *server) limitedHandle(req *transport.Message, resp *transport.Message, queued bool) (err error) {
 	path, ok := req.GetPath()
+
+	defer s.recoverPanic(path, &err)
+
+	if s.limiter != nil {
+		if queued {
+			if err := s.waitInQueue(req); err != nil {
+				s.log.Debugf("rejecting request to %s: %s", path, err)
+				return err
+			}
+		}
+		defer s.limiter.release()
+	}
+
 	if !ok {
-		s.log.Errorf("missing path header")
-		return
+		return errors.BadRequest("missing path header")
+	}
+
+	return s.router.Handle(context.Background(), path, req, resp)
+}
+
+// waitInQueue waits for a slot in the limiter for no longer than the queue timeout, or the caller's timeout if it's lower
+func (s *server) waitInQueue(req *transport.Message) error {
+	timeout := s.opts.QueueTimeout
+	if timeout <= 0 {
+		timeout = options.DefaultQueueTimeout
 	}
 
+	// There's no point in waiting for longer than the caller is willing to
+	if reqTimeout, ok := req.GetTimeout(); ok && reqTimeout < timeout {
+		timeout = reqTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return s.limiter.wait(ctx)
+}
+
+// reject sends an error in response to a request without handling it
+func (s *server) reject(req *transport.Message, soc transport.Socket, err error) {
+	path, _ := req.GetPath()
+	s.log.Debugf("rejecting request to %s: %s", path, err)
+
 	var resp transport.Message
 	resp.SetRequestID(req.MustGetRequestID())
+	resp.SetError(err)
 
-	ret, err := s.router.Handle(path, req)
+	s.sendResponse(soc, &resp)
+}
 
-	if err != nil {
-		resp.SetError(err)
-	} else {
-		resp.Data = ret
+// recoverPanic recovers from a panic that occurred while handling path outside of the handler itself, like in a
+// middleware or a codec, setting err to an internal server error if it isn't nil. It must be deferred.
+func (s *server) recoverPanic(path string, err *error) {
+	v := recover()
+	if v == nil {
+		return
 	}
 
-	if err := soc.Send(context.Background(), &resp); err != nil {
-		s.log.Errorf("send response: %s", err)
+	if s.opts.RepanicInDevelopment && s.opts.Environment.IsDevelopment() {
+		panic(v)
+	}
+
+	s.log.Errorf("panic while handling %s: %v\n%s", path, v, debug.Stack())
+
+	if err != nil {
+		*err = errors.InternalServerError("internal server error")
 	}
 }
 
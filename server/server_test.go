@@ -0,0 +1,395 @@
+package server
+
+import (
+	"context"
+	goerrors "errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/MouseHatGames/mice/codec/json"
+	"github.com/MouseHatGames/mice/errors"
+	"github.com/MouseHatGames/mice/logger/stdout"
+	"github.com/MouseHatGames/mice/middleware"
+	"github.com/MouseHatGames/mice/options"
+	"github.com/MouseHatGames/mice/tracing"
+	"github.com/MouseHatGames/mice/transport"
+	"github.com/MouseHatGames/mice/transport/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type empty struct{}
+
+type slowHandler struct {
+	release chan struct{}
+}
+
+func (h *slowHandler) Slow(ctx context.Context, req *empty, resp *empty) error {
+	<-h.release
+	return nil
+}
+
+func (h *slowHandler) Fast(ctx context.Context, req *empty, resp *empty) error {
+	return nil
+}
+
+func (h *slowHandler) Stream(ctx context.Context, stream Stream) error {
+	return nil
+}
+
+// Idle never receives the messages sent to the stream
+func (h *slowHandler) Idle(ctx context.Context, stream Stream) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// startServer starts a server with a slowHandler on a new in-memory network and returns a socket connected to it
+func startServer(t *testing.T, opts ...options.Option) (transport.Socket, *slowHandler, Server) {
+	o := &options.Options{
+		Name:    "test",
+		RPCPort: options.DefaultRPCPort,
+		Logger:  stdout.NewStdoutLogger(" "),
+		Tracer:  tracing.NoopTracer(),
+	}
+	json.Codec()(o)
+	memory.Transport(memory.WithNetwork(memory.NewNetwork()))(o)
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	h := &slowHandler{release: make(chan struct{})}
+
+	s := NewServer(o)
+	s.AddHandler(h, "test", "Slow", "Fast", "Stream", "Idle")
+	require.Nil(t, s.Start())
+	t.Cleanup(func() { s.Stop(context.Background()) })
+
+	soc, err := o.Transport.Dial(context.Background(), "test:7070")
+	require.Nil(t, err)
+	t.Cleanup(func() { soc.Close() })
+
+	return soc, h, s
+}
+
+func send(t *testing.T, soc transport.Socket, path string) *transport.Message {
+	req := transport.NewMessage()
+	req.SetRandomRequestID()
+	req.SetPath(path)
+	req.Data = []byte("{}")
+
+	require.Nil(t, soc.Send(context.Background(), req))
+	return req
+}
+
+func receive(soc transport.Socket, timeout time.Duration) (*transport.Message, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var resp transport.Message
+	err := soc.Receive(ctx, &resp)
+	return &resp, err
+}
+
+func TestConcurrentRequests(t *testing.T) {
+	soc, h, _ := startServer(t)
+
+	slow := send(t, soc, "test.Slow")
+	fast := send(t, soc, "test.Fast")
+
+	resp, err := receive(soc, time.Second)
+	require.Nil(t, err)
+	assert.Equal(t, fast.MustGetRequestID(), resp.MustGetRequestID(), "the fast request isn't blocked by the slow one")
+
+	close(h.release)
+
+	resp, err = receive(soc, time.Second)
+	require.Nil(t, err)
+	assert.Equal(t, slow.MustGetRequestID(), resp.MustGetRequestID())
+}
+
+func TestSocketConcurrency(t *testing.T) {
+	soc, h, _ := startServer(t, options.SocketConcurrency(1))
+
+	slow := send(t, soc, "test.Slow")
+	fast := send(t, soc, "test.Fast")
+
+	_, err := receive(soc, 50*time.Millisecond)
+	assert.ErrorIs(t, err, context.DeadlineExceeded, "requests are handled one at a time")
+
+	close(h.release)
+
+	for _, req := range []*transport.Message{slow, fast} {
+		resp, err := receive(soc, time.Second)
+		require.Nil(t, err)
+		assert.Equal(t, req.MustGetRequestID(), resp.MustGetRequestID())
+	}
+}
+
+func TestLoadShedding(t *testing.T) {
+	soc, h, _ := startServer(t, options.MaxConcurrentRequests(1), options.MaxQueuedRequests(1))
+
+	// One request is handled, another one waits in the queue and the last one doesn't fit
+	for i := 0; i < 3; i++ {
+		send(t, soc, "test.Slow")
+	}
+
+	resp, err := receive(soc, time.Second)
+	require.Nil(t, err)
+
+	rerr, ok := resp.GetError()
+	require.True(t, ok)
+
+	merr, ok := rerr.(*errors.Error)
+	require.True(t, ok)
+	assert.EqualValues(t, 503, merr.StatusCode)
+	assert.Equal(t, overloadRetryAfter, merr.RetryAfter)
+
+	close(h.release)
+
+	for i := 0; i < 2; i++ {
+		resp, err := receive(soc, time.Second)
+		require.Nil(t, err)
+
+		_, ok := resp.GetError()
+		assert.False(t, ok)
+	}
+}
+
+func TestQueueTimeout(t *testing.T) {
+	soc, h, _ := startServer(t, options.MaxConcurrentRequests(1), options.MaxQueuedRequests(1),
+		options.QueueTimeout(50*time.Millisecond))
+	defer close(h.release)
+
+	send(t, soc, "test.Slow")
+	queued := send(t, soc, "test.Fast")
+
+	// The queued request has no timeout of its own, but it doesn't wait for the slow one forever
+	resp, err := receive(soc, time.Second)
+	require.Nil(t, err)
+	assert.Equal(t, queued.MustGetRequestID(), resp.MustGetRequestID())
+
+	rerr, ok := resp.GetError()
+	require.True(t, ok)
+	assert.EqualValues(t, 503, rerr.(*errors.Error).StatusCode)
+}
+
+func TestStopWaitsForRequests(t *testing.T) {
+	soc, h, s := startServer(t)
+
+	req := send(t, soc, "test.Slow")
+
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- s.Stop(context.Background())
+	}()
+
+	select {
+	case <-stopped:
+		t.Fatal("server stopped before the request was handled")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(h.release)
+
+	resp, err := receive(soc, time.Second)
+	require.Nil(t, err)
+	assert.Equal(t, req.MustGetRequestID(), resp.MustGetRequestID())
+
+	assert.Nil(t, <-stopped)
+}
+
+func TestRecoverMiddlewarePanic(t *testing.T) {
+	soc, _, _ := startServer(t, options.ServerMiddleware(func(next middleware.HandlerFunc) middleware.HandlerFunc {
+		return func(ctx context.Context, req *middleware.Request) (interface{}, error) {
+			panic("middleware panic")
+		}
+	}))
+
+	for i := 0; i < 2; i++ {
+		send(t, soc, "test.Fast")
+
+		resp, err := receive(soc, time.Second)
+		require.Nil(t, err, "the server is still running")
+
+		rerr, ok := resp.GetError()
+		require.True(t, ok)
+		assert.EqualValues(t, 500, rerr.(*errors.Error).StatusCode)
+	}
+
+	open := transport.NewMessage()
+	open.SetRandomRequestID()
+	open.SetPath("test.Stream")
+	open.SetStream(transport.StreamOpen)
+	require.Nil(t, soc.Send(context.Background(), open))
+
+	resp, err := receive(soc, time.Second)
+	require.Nil(t, err)
+
+	kind, _ := resp.GetStream()
+	assert.Equal(t, transport.StreamClose, kind)
+
+	rerr, ok := resp.GetError()
+	require.True(t, ok)
+	assert.EqualValues(t, 500, rerr.(*errors.Error).StatusCode)
+}
+
+// panickySocket delivers a single message and then panics when receiving again
+type panickySocket struct {
+	first  chan *transport.Message
+	sent   chan *transport.Message
+	closed chan struct{}
+}
+
+func (s *panickySocket) Receive(ctx context.Context, msg *transport.Message) error {
+	select {
+	case m := <-s.first:
+		*msg = *m
+		return nil
+	default:
+		panic("receive panic")
+	}
+}
+
+func (s *panickySocket) Send(ctx context.Context, msg *transport.Message) error {
+	s.sent <- msg
+	return nil
+}
+
+func (s *panickySocket) Close() error {
+	close(s.closed)
+	return nil
+}
+
+func TestSocketPanicWaitsForRequests(t *testing.T) {
+	_, h, s := startServer(t)
+
+	soc := &panickySocket{
+		first:  make(chan *transport.Message, 1),
+		sent:   make(chan *transport.Message),
+		closed: make(chan struct{}),
+	}
+
+	req := transport.NewMessage()
+	req.SetRandomRequestID()
+	req.SetPath("test.Slow")
+	req.Data = []byte("{}")
+	soc.first <- req
+
+	s.(*server).handle(soc)
+
+	select {
+	case <-soc.closed:
+		t.Fatal("socket closed before the request was handled")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(h.release)
+
+	select {
+	case resp := <-soc.sent:
+		assert.Equal(t, req.MustGetRequestID(), resp.MustGetRequestID())
+	case <-soc.closed:
+		t.Fatal("socket closed before the response was sent")
+	}
+
+	<-soc.closed
+}
+
+// smallSocket delivers a single message and rejects the messages sent to it that have any data
+type smallSocket struct {
+	first chan *transport.Message
+	sent  chan *transport.Message
+}
+
+func (s *smallSocket) Receive(ctx context.Context, msg *transport.Message) error {
+	select {
+	case m := <-s.first:
+		*msg = *m
+		return nil
+	default:
+		return io.EOF
+	}
+}
+
+func (s *smallSocket) Send(ctx context.Context, msg *transport.Message) error {
+	if len(msg.Data) > 0 {
+		return &transport.InvalidMessageError{Err: goerrors.New("message is too large")}
+	}
+
+	s.sent <- msg
+	return nil
+}
+
+func (s *smallSocket) Close() error {
+	return nil
+}
+
+func TestResponseTooLarge(t *testing.T) {
+	_, _, s := startServer(t)
+
+	soc := &smallSocket{
+		first: make(chan *transport.Message, 1),
+		sent:  make(chan *transport.Message, 1),
+	}
+
+	req := transport.NewMessage()
+	req.SetRandomRequestID()
+	req.SetPath("test.Fast")
+	req.Data = []byte("{}")
+	soc.first <- req
+
+	s.(*server).handle(soc)
+
+	select {
+	case resp := <-soc.sent:
+		assert.Equal(t, req.MustGetRequestID(), resp.MustGetRequestID())
+
+		rerr, ok := resp.GetError()
+		require.True(t, ok, "the client is told that the response couldn't be sent")
+		assert.EqualValues(t, 500, rerr.(*errors.Error).StatusCode)
+
+	case <-time.After(time.Second):
+		t.Fatal("no response was sent")
+	}
+}
+
+func TestStreamOverflow(t *testing.T) {
+	soc, _, _ := startServer(t)
+
+	open := transport.NewMessage()
+	open.SetRandomRequestID()
+	open.SetPath("test.Idle")
+	open.SetStream(transport.StreamOpen)
+	require.Nil(t, soc.Send(context.Background(), open))
+
+	// Send more messages than the flow control allows without waiting for acknowledgements
+	for i := 0; i <= transport.StreamWindow; i++ {
+		data := transport.NewMessage()
+		data.SetRequestID(open.MustGetRequestID())
+		data.SetStream(transport.StreamData)
+		data.Data = []byte("{}")
+		require.Nil(t, soc.Send(context.Background(), data))
+	}
+
+	// Requests on the same socket aren't blocked by the stream
+	req := send(t, soc, "test.Fast")
+
+	for i := 0; i < 2; i++ {
+		resp, err := receive(soc, time.Second)
+		require.Nil(t, err)
+
+		if resp.MustGetRequestID() == req.MustGetRequestID() {
+			_, ok := resp.GetError()
+			assert.False(t, ok)
+			continue
+		}
+
+		kind, _ := resp.GetStream()
+		assert.Equal(t, transport.StreamClose, kind)
+
+		rerr, _ := resp.GetError()
+		assert.Equal(t, ErrStreamOverflow, rerr)
+	}
+}
@@ -0,0 +1,286 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/MouseHatGames/mice/codec"
+	"github.com/MouseHatGames/mice/errors"
+	"github.com/MouseHatGames/mice/server/router"
+	"github.com/MouseHatGames/mice/transport"
+	"github.com/google/uuid"
+)
+
+// Stream is the stream that streaming handlers receive, see router.Stream
+type Stream = router.Stream
+
+// ErrStreamOverflow is returned to clients that send more stream messages than the flow control allows
+var ErrStreamOverflow = errors.BadRequest("stream messages were sent without waiting for acknowledgements")
+
+// serverStream is the server side of a stream opened by a client
+type serverStream struct {
+	id  uuid.UUID
+	soc transport.Socket
+
+	// in and out are the codecs that messages from and to the client are encoded with
+	in  codec.Codec
+	out codec.Codec
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// flow limits the messages sent to the client and acknowledges the ones received from it
+	flow *transport.StreamFlow
+
+	// inbox receives the messages sent by the client, and is closed once the client closes its side of the stream
+	inbox       chan *transport.Message
+	inboxClosed bool
+
+	// overflowed is set to 1 if the client didn't respect the flow control, which cancels the stream
+	overflowed int32
+}
+
+var _ Stream = (*serverStream)(nil)
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *serverStream) Send(msg interface{}) error {
+	data, err := s.out.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("encode message: %w", err)
+	}
+
+	if err := s.flow.Acquire(s.ctx); err != nil {
+		return err
+	}
+
+	m := s.newMessage(transport.StreamData)
+	m.SetContentType(codec.ContentType(s.out))
+	m.Data = data
+
+	if err := s.soc.Send(s.ctx, m); err != nil {
+		return fmt.Errorf("send message: %w", err)
+	}
+
+	return nil
+}
+
+func (s *serverStream) Receive(msg interface{}) error {
+	select {
+	case m, ok := <-s.inbox:
+		if !ok {
+			return io.EOF
+		}
+
+		if n := s.flow.Consume(); n > 0 {
+			ack := s.newMessage(transport.StreamAck)
+			ack.SetCredit(n)
+
+			if err := s.soc.Send(s.ctx, ack); err != nil {
+				return fmt.Errorf("send acknowledgement: %w", err)
+			}
+		}
+
+		if err := s.in.Unmarshal(m.Data, msg); err != nil {
+			return fmt.Errorf("decode message: %w", err)
+		}
+		return nil
+
+	case <-s.ctx.Done():
+		return s.ctx.Err()
+	}
+}
+
+func (s *serverStream) newMessage(kind string) *transport.Message {
+	m := transport.NewMessage()
+	m.SetRequestID(s.id)
+	m.SetStream(kind)
+	return m
+}
+
+// deliver passes a message sent by the client to the handler. The inbox has room for all the messages that the client
+// can send without an acknowledgement, so if it's full the client isn't respecting the flow control and the stream is
+// cancelled, since waiting would block every other request on the socket.
+func (s *serverStream) deliver(m *transport.Message) {
+	if s.inboxClosed {
+		return
+	}
+
+	select {
+	case s.inbox <- m:
+	default:
+		atomic.StoreInt32(&s.overflowed, 1)
+		s.cancel()
+	}
+}
+
+func (s *serverStream) closeInbox() {
+	if !s.inboxClosed {
+		s.inboxClosed = true
+		close(s.inbox)
+	}
+}
+
+// socketStreams holds the streams that have been opened on a socket
+type socketStreams struct {
+	soc     transport.Socket
+	streams map[uuid.UUID]*serverStream
+	mu      sync.Mutex
+
+	// handlers tracks the handlers of the socket's streams, so that the socket isn't closed before they're done
+	handlers sync.WaitGroup
+}
+
+func newSocketStreams(soc transport.Socket) *socketStreams {
+	return &socketStreams{
+		soc:     soc,
+		streams: make(map[uuid.UUID]*serverStream),
+	}
+}
+
+func (ss *socketStreams) get(id uuid.UUID) (*serverStream, bool) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	st, ok := ss.streams[id]
+	return st, ok
+}
+
+func (ss *socketStreams) remove(id uuid.UUID) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	delete(ss.streams, id)
+}
+
+// closeAll cancels all streams, which is done once the client can't send any more messages through the socket
+func (ss *socketStreams) closeAll() {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	for _, st := range ss.streams {
+		st.closeInbox()
+		st.cancel()
+	}
+}
+
+// handleStreamMessage handles a message that belongs to a stream. It must only be called by the goroutine that
+// receives messages from the socket.
+func (s *server) handleStreamMessage(kind string, msg *transport.Message, streams *socketStreams) {
+	id, ok := msg.GetRequestID()
+	if !ok {
+		s.log.Errorf("missing request id on stream message")
+		return
+	}
+
+	if kind == transport.StreamOpen {
+		s.openStream(id, msg, streams)
+		return
+	}
+
+	st, ok := streams.get(id)
+	if !ok {
+		s.log.Debugf("dropping message to unknown stream %s", id)
+		return
+	}
+
+	switch kind {
+	case transport.StreamData:
+		st.deliver(msg)
+	case transport.StreamAck:
+		if n, ok := msg.GetCredit(); ok {
+			st.flow.Grant(n)
+		}
+	case transport.StreamClose:
+		st.closeInbox()
+	case transport.StreamCancel:
+		st.cancel()
+	default:
+		s.log.Errorf("unknown stream message kind %q", kind)
+	}
+}
+
+func (s *server) openStream(id uuid.UUID, req *transport.Message, streams *socketStreams) {
+	path, ok := req.GetPath()
+	if !ok {
+		s.log.Errorf("missing path header")
+		return
+	}
+
+	in, err := router.RequestCodec(s.opts, req)
+	if err != nil {
+		resp := transport.NewMessage()
+		resp.SetRequestID(id)
+		resp.SetStream(transport.StreamClose)
+		resp.SetAccept(s.opts.ContentTypes())
+		resp.SetError(err)
+
+		if err := streams.soc.Send(context.Background(), resp); err != nil {
+			s.log.Errorf("send stream close: %s", err)
+		}
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	st := &serverStream{
+		id:     id,
+		soc:    streams.soc,
+		in:     in,
+		out:    router.ResponseCodec(s.opts, req, in),
+		ctx:    ctx,
+		cancel: cancel,
+		flow:   transport.NewStreamFlow(),
+		inbox:  make(chan *transport.Message, transport.StreamWindow),
+	}
+
+	streams.mu.Lock()
+	if _, ok := streams.streams[id]; ok {
+		streams.mu.Unlock()
+		cancel()
+
+		s.log.Errorf("stream %s is already open", id)
+		return
+	}
+	streams.streams[id] = st
+	streams.mu.Unlock()
+
+	streams.handlers.Add(1)
+
+	go func() {
+		defer streams.handlers.Done()
+		defer streams.remove(id)
+		defer cancel()
+
+		err := func() (err error) {
+			defer s.recoverPanic(path, &err)
+			return s.router.HandleStream(ctx, path, req, st)
+		}()
+
+		if atomic.LoadInt32(&st.overflowed) == 1 {
+			err = ErrStreamOverflow
+		}
+
+		resp := transport.NewMessage()
+		resp.SetRequestID(id)
+		resp.SetStream(transport.StreamClose)
+
+		if err != nil {
+			resp.SetError(err)
+		}
+
+		if err := streams.soc.Send(context.Background(), resp); err != nil {
+			if ctx.Err() != nil {
+				// The client is gone, so there's nobody to tell that the stream is over
+				s.log.Debugf("send stream close: %s", err)
+			} else {
+				s.log.Errorf("send stream close: %s", err)
+			}
+		}
+	}()
+}
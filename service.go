@@ -1,13 +1,21 @@
 package mice
 
 import (
+	"context"
 	"errors"
 	"flag"
 	"fmt"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/MouseHatGames/mice/client"
 	"github.com/MouseHatGames/mice/config"
+	"github.com/MouseHatGames/mice/health"
 	"github.com/MouseHatGames/mice/logger/stdout"
 	"github.com/MouseHatGames/mice/options"
 	"github.com/MouseHatGames/mice/server"
@@ -26,25 +34,55 @@ type Service interface {
 	Server() server.Server
 	Client() client.Client
 
+	// Start starts the service and blocks until it is stopped, either by calling Stop or by receiving SIGINT or SIGTERM
 	Start() error
+
+	// Stop stops accepting requests, waits for in-flight ones to finish until ctx is done and then closes all components
+	Stop(ctx context.Context) error
 }
 
 type starter interface {
 	Start() error
 }
 
+// Stopper can be implemented by components (broker, config, discovery, etc) that need to release resources when
+// the service stops. Components that don't implement it but have a Close() error method will be closed instead.
+type Stopper interface {
+	Stop(ctx context.Context) error
+}
+
+type closer interface {
+	Close() error
+}
+
+type component struct {
+	name  string
+	value interface{}
+}
+
 type service struct {
 	options options.Options
 	server  server.Server
 	client  client.Client
+
+	started      []component
+	healthServer *http.Server
+	stopOnce     sync.Once
+	stopErr      error
+	stopped      chan struct{}
 }
 
 // NewService instantiates a new service and initializes it with options
 func NewService(opts ...options.Option) Service {
-	svc := &service{}
+	svc := &service{
+		stopped: make(chan struct{}),
+	}
 	svc.options.RPCPort = options.DefaultRPCPort
+	svc.options.ShutdownTimeout = options.DefaultShutdownTimeout
+	svc.options.ReadinessDrainDelay = options.DefaultReadinessDrainDelay
 	svc.options.Environment = getEnvironment()
 	svc.options.Tracer = tracing.NoopTracer()
+	svc.options.Health = health.NewHealth()
 
 	svc.Apply(stdout.Logger())
 	svc.Apply(opts...)
@@ -107,29 +145,228 @@ func (s *service) Start() error {
 		return errors.New("missing service name")
 	}
 
-	if err := tryStart(map[string]interface{}{
-		"broker":    s.options.Broker,
-		"codec":     s.options.Codec,
-		"config":    s.options.Config,
-		"discovery": s.options.Discovery,
-		"logger":    s.options.Logger,
-		"transport": s.options.Transport,
-	}); err != nil {
+	if err := runHooks(s.options.BeforeStart); err != nil {
+		return fmt.Errorf("before start: %w", err)
+	}
+
+	if err := s.startComponents(s.components()); err != nil {
 		return err
 	}
 
+	s.registerHealthChecks()
+
+	if s.options.HealthPort != 0 {
+		if err := s.startHealthServer(); err != nil {
+			if stopErr := s.shutdown(); stopErr != nil {
+				s.options.Logger.Errorf("failed to stop: %s", stopErr)
+			}
+
+			return fmt.Errorf("start health server: %w", err)
+		}
+	}
+
 	s.options.Logger.Infof("starting on %s environment", s.options.Environment)
 
-	return s.server.Start()
+	if err := s.server.Start(); err != nil {
+		if stopErr := s.shutdown(); stopErr != nil {
+			s.options.Logger.Errorf("failed to stop: %s", stopErr)
+		}
+
+		return fmt.Errorf("start server: %w", err)
+	}
+
+	if err := runHooks(s.options.AfterStart); err != nil {
+		if stopErr := s.shutdown(); stopErr != nil {
+			s.options.Logger.Errorf("failed to stop: %s", stopErr)
+		}
+
+		return fmt.Errorf("after start: %w", err)
+	}
+
+	s.options.Health.SetReady(true)
+
+	return s.wait()
+}
+
+// registerHealthChecks registers the checks of all started components that implement health.Checker
+func (s *service) registerHealthChecks() {
+	for _, c := range s.started {
+		if ch, ok := c.value.(health.Checker); ok {
+			s.options.Health.Register(c.name, ch.HealthCheck)
+		}
+	}
+}
+
+func (s *service) startHealthServer() error {
+	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", s.options.HealthPort))
+	if err != nil {
+		return err
+	}
+
+	s.healthServer = &http.Server{Handler: s.options.Health.Handler()}
+
+	go func() {
+		if err := s.healthServer.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.options.Logger.Errorf("serve health endpoints: %s", err)
+		}
+	}()
+
+	s.options.Logger.Infof("serving health endpoints on port %d", s.options.HealthPort)
+	return nil
+}
+
+// components returns the service's components in the order in which they must be started
+func (s *service) components() []component {
+	return []component{
+		{"logger", s.options.Logger},
+		{"codec", s.options.Codec},
+		{"config", s.options.Config},
+		{"discovery", s.options.Discovery},
+		{"broker", s.options.Broker},
+		{"transport", s.options.Transport},
+	}
 }
 
-func tryStart(objs map[string]interface{}) error {
-	for k, v := range objs {
-		if s, ok := v.(starter); ok {
-			if err := s.Start(); err != nil {
-				return fmt.Errorf("start %s: %w", k, err)
+// startComponents starts all components in order. If one of them fails to start, the ones that had already been
+// started are stopped in reverse order.
+func (s *service) startComponents(objs []component) error {
+	for _, c := range objs {
+		if st, ok := c.value.(starter); ok {
+			if err := st.Start(); err != nil {
+				ctx, cancel := context.WithTimeout(context.Background(), s.options.ShutdownTimeout)
+				defer cancel()
+
+				if stopErr := stopComponents(ctx, s.started); stopErr != nil {
+					s.options.Logger.Errorf("failed to roll back: %s", stopErr)
+				}
+				s.started = nil
+
+				return fmt.Errorf("start %s: %w", c.name, err)
 			}
 		}
+
+		s.started = append(s.started, c)
+	}
+
+	return nil
+}
+
+// stopComponents stops components in reverse order, returning the first error that occurs
+func stopComponents(ctx context.Context, objs []component) error {
+	var firstErr error
+
+	for i := len(objs) - 1; i >= 0; i-- {
+		var err error
+
+		switch v := objs[i].value.(type) {
+		case Stopper:
+			err = v.Stop(ctx)
+		case closer:
+			err = v.Close()
+		}
+
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("stop %s: %w", objs[i].name, err)
+		}
+	}
+
+	return firstErr
+}
+
+// shutdown stops the service, giving in-flight requests ShutdownTimeout to finish
+func (s *service) shutdown() error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.options.ShutdownTimeout)
+	defer cancel()
+
+	return s.Stop(ctx)
+}
+
+func (s *service) wait() error {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sig)
+
+	select {
+	case v := <-sig:
+		s.options.Logger.Infof("received %s, shutting down", v)
+
+		return s.shutdown()
+
+	case err := <-s.server.Err():
+		if stopErr := s.shutdown(); stopErr != nil {
+			s.options.Logger.Errorf("failed to stop: %s", stopErr)
+		}
+
+		return err
+
+	case <-s.stopped:
+		return nil
+	}
+}
+
+func (s *service) Stop(ctx context.Context) error {
+	s.stopOnce.Do(func() {
+		s.stopErr = s.stop(ctx)
+		close(s.stopped)
+	})
+
+	return s.stopErr
+}
+
+func (s *service) stop(ctx context.Context) error {
+	s.options.Logger.Infof("stopping")
+
+	// Report as not ready while draining so that no new requests are routed to this instance. Requests keep being
+	// accepted for a while since load balancers only find out once they probe readiness again.
+	wasReady := s.options.Health.Ready()
+	s.options.Health.SetReady(false)
+
+	if wasReady && s.options.ReadinessDrainDelay > 0 {
+		s.options.Logger.Infof("waiting %s for the service to stop receiving requests", s.options.ReadinessDrainDelay)
+
+		select {
+		case <-time.After(s.options.ReadinessDrainDelay):
+		case <-ctx.Done():
+		}
+	}
+
+	var firstErr error
+	setErr := func(err error) {
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	if err := runHooks(s.options.BeforeStop); err != nil {
+		setErr(fmt.Errorf("before stop: %w", err))
+	}
+
+	if err := s.server.Stop(ctx); err != nil {
+		setErr(fmt.Errorf("stop server: %w", err))
+	}
+
+	// Stop components in reverse order of dependency even if the server couldn't be drained in time
+	setErr(stopComponents(ctx, s.started))
+	s.started = nil
+
+	if s.healthServer != nil {
+		if err := s.healthServer.Shutdown(ctx); err != nil {
+			setErr(fmt.Errorf("stop health server: %w", err))
+		}
+	}
+
+	if err := runHooks(s.options.AfterStop); err != nil {
+		setErr(fmt.Errorf("after stop: %w", err))
+	}
+
+	return firstErr
+}
+
+func runHooks(hooks []func() error) error {
+	for _, fn := range hooks {
+		if err := fn(); err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -0,0 +1,408 @@
+package mice
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MouseHatGames/mice/broker"
+	"github.com/MouseHatGames/mice/codec/json"
+	"github.com/MouseHatGames/mice/discovery"
+	"github.com/MouseHatGames/mice/health"
+	"github.com/MouseHatGames/mice/logger/stdout"
+	"github.com/MouseHatGames/mice/options"
+	"github.com/MouseHatGames/mice/transport"
+	"github.com/MouseHatGames/mice/transport/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockComponent struct {
+	name     string
+	startErr error
+	log      *[]string
+}
+
+func (c *mockComponent) Start() error {
+	*c.log = append(*c.log, "start "+c.name)
+	return c.startErr
+}
+
+func (c *mockComponent) Stop(ctx context.Context) error {
+	*c.log = append(*c.log, "stop "+c.name)
+	return nil
+}
+
+func TestStopComponents(t *testing.T) {
+	var log []string
+
+	err := stopComponents(context.Background(), []component{
+		{"a", &mockComponent{name: "a", log: &log}},
+		{"b", nil},
+		{"c", &mockComponent{name: "c", log: &log}},
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"stop c", "stop a"}, log)
+}
+
+func TestStartComponentsRollback(t *testing.T) {
+	var log []string
+	starterr := errors.New("failed")
+
+	s := &service{}
+	s.options.Logger = stdout.NewStdoutLogger(" ")
+	s.options.ShutdownTimeout = options.DefaultShutdownTimeout
+
+	err := s.startComponents([]component{
+		{"config", &mockComponent{name: "config", log: &log}},
+		{"discovery", &mockComponent{name: "discovery", log: &log, startErr: starterr}},
+		{"broker", &mockComponent{name: "broker", log: &log}},
+	})
+
+	assert.ErrorIs(t, err, starterr)
+	assert.Equal(t, []string{"start config", "start discovery", "stop config"}, log)
+	assert.Empty(t, s.started)
+}
+
+// eventLog records things that happen in different goroutines while a service runs
+type eventLog struct {
+	events []string
+	mu     sync.Mutex
+}
+
+func (l *eventLog) add(event string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.events = append(l.events, event)
+}
+
+func (l *eventLog) get() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return append([]string(nil), l.events...)
+}
+
+// loggedDiscovery and loggedBroker record when they're stopped, through Stopper and Close respectively
+type loggedDiscovery struct {
+	discovery.Discovery
+	log *eventLog
+}
+
+func (d *loggedDiscovery) Stop(ctx context.Context) error {
+	d.log.add("stop discovery")
+	return nil
+}
+
+type loggedBroker struct {
+	broker.Broker
+	log *eventLog
+}
+
+func (b *loggedBroker) Close() error {
+	b.log.add("close broker")
+	return nil
+}
+
+type blockingHandler struct {
+	entered chan struct{}
+	release chan struct{}
+	log     *eventLog
+}
+
+func (h *blockingHandler) Block(ctx context.Context, req *struct{}, resp *struct{}) error {
+	close(h.entered)
+	<-h.release
+
+	h.log.add("handled")
+	return nil
+}
+
+// startBlockingService starts a service on the memory transport and sends it a request that blocks until
+// h.release is closed. It returns the service, the socket that the request was sent through and the error that
+// Start returns once the service stops.
+func startBlockingService(t *testing.T, log *eventLog, opts ...options.Option) (*service, *blockingHandler, transport.Socket, <-chan error) {
+	n := memory.NewNetwork()
+	started := make(chan struct{})
+
+	svc := NewService(append([]options.Option{
+		options.Name("test"),
+		json.Codec(),
+		memory.Transport(memory.WithNetwork(n)),
+		options.ReadinessDrainDelay(0),
+		func(o *options.Options) {
+			o.Discovery = &loggedDiscovery{log: log}
+			o.Broker = &loggedBroker{log: log}
+		},
+		options.AfterStart(func() error {
+			close(started)
+			return nil
+		}),
+	}, opts...)...).(*service)
+
+	h := &blockingHandler{entered: make(chan struct{}), release: make(chan struct{}), log: log}
+	svc.Server().AddHandler(h, "blocking", "Block")
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- svc.Start()
+	}()
+	<-started
+
+	soc, err := svc.options.Transport.Dial(context.Background(), "test:7070")
+	require.Nil(t, err)
+	t.Cleanup(func() { soc.Close() })
+
+	req := transport.NewMessage()
+	req.SetRandomRequestID()
+	req.SetPath("blocking.Block")
+	req.Data = []byte("{}")
+	require.Nil(t, soc.Send(context.Background(), req))
+
+	<-h.entered
+	return svc, h, soc, errc
+}
+
+func TestShutdownWaitsForRequests(t *testing.T) {
+	var log eventLog
+	svc, h, soc, errc := startBlockingService(t, &log, options.ShutdownTimeout(time.Second))
+
+	// This is what happens when the service receives SIGINT or SIGTERM
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- svc.shutdown()
+	}()
+
+	select {
+	case <-stopped:
+		t.Fatal("service stopped before the request was handled")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(h.release)
+
+	var resp transport.Message
+	require.Nil(t, soc.Receive(context.Background(), &resp))
+	_, hasErr := resp.GetError()
+	assert.False(t, hasErr, "the request succeeds")
+
+	assert.Nil(t, <-stopped)
+	assert.Nil(t, <-errc)
+	assert.Equal(t, []string{"handled", "close broker", "stop discovery"}, log.get(),
+		"components are stopped in reverse order once the request is handled")
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	var log eventLog
+	svc, h, _, errc := startBlockingService(t, &log, options.ShutdownTimeout(50*time.Millisecond))
+	defer close(h.release)
+
+	start := time.Now()
+	err := svc.shutdown()
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second, "the stuck request is cut off")
+	assert.Nil(t, <-errc)
+	assert.Equal(t, []string{"close broker", "stop discovery"}, log.get(), "components are stopped anyway")
+}
+
+// startedDiscovery also records when it's started
+type startedDiscovery struct {
+	loggedDiscovery
+}
+
+func (d *startedDiscovery) Start() error {
+	d.log.add("start discovery")
+	return nil
+}
+
+// newHookService creates a service on the memory transport whose components log when they start and stop
+func newHookService(log *eventLog, opts ...options.Option) *service {
+	return NewService(append([]options.Option{
+		options.Name("test"),
+		json.Codec(),
+		memory.Transport(memory.WithNetwork(memory.NewNetwork())),
+		options.ReadinessDrainDelay(0),
+		func(o *options.Options) {
+			o.Discovery = &startedDiscovery{loggedDiscovery{log: log}}
+			o.Broker = &loggedBroker{log: log}
+		},
+	}, opts...)...).(*service)
+}
+
+// hook returns a hook that logs its name and returns err
+func hook(log *eventLog, name string, err error) func() error {
+	return func() error {
+		log.add(name)
+		return err
+	}
+}
+
+func TestHooks(t *testing.T) {
+	var log eventLog
+	started := make(chan struct{})
+
+	svc := newHookService(&log,
+		options.BeforeStart(hook(&log, "before start 1", nil)),
+		options.BeforeStart(hook(&log, "before start 2", nil)),
+		options.AfterStart(hook(&log, "after start 1", nil)),
+		options.AfterStart(hook(&log, "after start 2", nil)),
+		options.AfterStart(func() error {
+			close(started)
+			return nil
+		}),
+		options.BeforeStop(hook(&log, "before stop 1", nil)),
+		options.BeforeStop(hook(&log, "before stop 2", nil)),
+		options.AfterStop(hook(&log, "after stop 1", nil)),
+		options.AfterStop(hook(&log, "after stop 2", nil)),
+	)
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- svc.Start()
+	}()
+	<-started
+
+	require.Nil(t, svc.Stop(context.Background()))
+	require.Nil(t, <-errc)
+
+	assert.Equal(t, []string{
+		"before start 1", "before start 2",
+		"start discovery",
+		"after start 1", "after start 2",
+		"before stop 1", "before stop 2",
+		"close broker", "stop discovery",
+		"after stop 1", "after stop 2",
+	}, log.get())
+}
+
+func TestBeforeStartError(t *testing.T) {
+	var log eventLog
+	hookErr := errors.New("failed")
+
+	svc := newHookService(&log,
+		options.BeforeStart(hook(&log, "before start 1", hookErr)),
+		options.BeforeStart(hook(&log, "before start 2", nil)),
+	)
+
+	assert.ErrorIs(t, svc.Start(), hookErr)
+	assert.Equal(t, []string{"before start 1"}, log.get(), "nothing else runs")
+
+	_, err := svc.options.Transport.Dial(context.Background(), "test:7070")
+	assert.ErrorIs(t, err, memory.ErrConnectionRefused, "the server isn't listening")
+}
+
+func TestAfterStartError(t *testing.T) {
+	var log eventLog
+	hookErr := errors.New("failed")
+
+	svc := newHookService(&log,
+		options.AfterStart(hook(&log, "after start", hookErr)),
+		options.BeforeStop(hook(&log, "before stop", nil)),
+		options.AfterStop(hook(&log, "after stop", nil)),
+	)
+
+	assert.ErrorIs(t, svc.Start(), hookErr)
+	assert.Equal(t, []string{
+		"start discovery",
+		"after start",
+		"before stop",
+		"close broker", "stop discovery",
+		"after stop",
+	}, log.get(), "the service is stopped")
+
+	_, err := svc.options.Transport.Dial(context.Background(), "test:7070")
+	assert.ErrorIs(t, err, memory.ErrConnectionRefused, "the server isn't listening anymore")
+}
+
+func TestStopHookErrors(t *testing.T) {
+	beforeErr := errors.New("before stop failed")
+	afterErr := errors.New("after stop failed")
+
+	tests := []struct {
+		name      string
+		beforeErr error
+		afterErr  error
+		want      error
+	}{
+		{"before stop", beforeErr, nil, beforeErr},
+		{"after stop", nil, afterErr, afterErr},
+		{"both", beforeErr, afterErr, beforeErr},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var log eventLog
+			started := make(chan struct{})
+
+			svc := newHookService(&log,
+				options.AfterStart(func() error {
+					close(started)
+					return nil
+				}),
+				options.BeforeStop(hook(&log, "before stop", tt.beforeErr)),
+				options.AfterStop(hook(&log, "after stop", tt.afterErr)),
+			)
+
+			go svc.Start()
+			<-started
+
+			assert.ErrorIs(t, svc.Stop(context.Background()), tt.want)
+			assert.Equal(t, []string{
+				"start discovery",
+				"before stop",
+				"close broker", "stop discovery",
+				"after stop",
+			}, log.get(), "the service stops anyway")
+		})
+	}
+}
+
+func TestReadinessDrainDelay(t *testing.T) {
+	var log eventLog
+	started := make(chan struct{})
+
+	svc := newHookService(&log,
+		options.ReadinessDrainDelay(200*time.Millisecond),
+		options.AfterStart(func() error {
+			close(started)
+			return nil
+		}),
+	)
+
+	go svc.Start()
+	<-started
+
+	readiness := func() int {
+		rec := httptest.NewRecorder()
+		svc.options.Health.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, health.PathReadiness, nil))
+		return rec.Code
+	}
+	require.Equal(t, http.StatusOK, readiness())
+
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- svc.Stop(context.Background())
+	}()
+
+	require.Eventually(t, func() bool { return readiness() == http.StatusServiceUnavailable }, time.Second, time.Millisecond)
+
+	soc, err := svc.options.Transport.Dial(context.Background(), "test:7070")
+	require.Nil(t, err, "requests are still accepted while not ready")
+	soc.Close()
+
+	select {
+	case <-stopped:
+		t.Fatal("service stopped before the drain delay")
+	default:
+	}
+
+	assert.Nil(t, <-stopped)
+	assert.Equal(t, http.StatusServiceUnavailable, readiness())
+}
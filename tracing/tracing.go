@@ -10,7 +10,8 @@ import (
 
 var propagator = &propagation.TraceContext{}
 
-const headerPrefix = "tracing-"
+// HeaderPrefix is the prefix of the message headers that carry the trace context
+const HeaderPrefix = "tracing-"
 
 func ExtractFromMessage(ctx context.Context, msg *transport.Message) context.Context {
 	if msg == nil {
@@ -31,18 +32,18 @@ type carrierMessage struct {
 }
 
 func (c *carrierMessage) Get(key string) string {
-	return c.msg.MessageHeaders[headerPrefix+key]
+	return c.msg.MessageHeaders[HeaderPrefix+key]
 }
 
 func (c *carrierMessage) Set(key string, value string) {
-	c.msg.MessageHeaders[headerPrefix+key] = value
+	c.msg.MessageHeaders[HeaderPrefix+key] = value
 }
 
 func (c *carrierMessage) Keys() []string {
 	keys := make([]string, 0, len(c.msg.MessageHeaders))
 
 	for h := range c.msg.MessageHeaders {
-		if strings.HasPrefix(h, headerPrefix) {
+		if strings.HasPrefix(h, HeaderPrefix) {
 			keys = append(keys, h)
 		}
 	}
@@ -0,0 +1,196 @@
+package compress
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/MouseHatGames/mice/options"
+	"github.com/MouseHatGames/mice/transport"
+)
+
+type compressTransport struct {
+	transport.Transport
+	opts Options
+
+	// accepted holds the encodings that each dialed address has said it accepts
+	accepted   map[string][]string
+	acceptedMu sync.Mutex
+}
+
+// Compression wraps the service's transport so that the data of messages is compressed, which must be applied after
+// the option that sets the transport. Every message lists the encodings its sender accepts, and data is only compressed
+// once the other side has listed one of ours, so services that don't support compression keep working.
+func Compression(opts ...Option) options.Option {
+	return func(o *options.Options) {
+		if o.Transport == nil {
+			panic("no transport defined")
+		}
+
+		copts := defaultOptions()
+		for _, opt := range opts {
+			opt(&copts)
+		}
+
+		for _, name := range copts.Encodings {
+			if _, ok := encodings[name]; !ok {
+				panic(fmt.Sprintf("unknown encoding %q", name))
+			}
+		}
+
+		o.Transport = &compressTransport{
+			Transport: o.Transport,
+			opts:      copts,
+			accepted:  make(map[string][]string),
+		}
+	}
+}
+
+func (t *compressTransport) Listen(ctx context.Context, addr string) (transport.Listener, error) {
+	l, err := t.Transport.Listen(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &compressListener{l, t}, nil
+}
+
+func (t *compressTransport) Dial(ctx context.Context, addr string) (transport.Socket, error) {
+	s, err := t.Transport.Dial(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &compressSocket{Socket: s, t: t, addr: addr}, nil
+}
+
+func (t *compressTransport) getAccepted(addr string) []string {
+	t.acceptedMu.Lock()
+	defer t.acceptedMu.Unlock()
+
+	return t.accepted[addr]
+}
+
+func (t *compressTransport) setAccepted(addr string, names []string) {
+	t.acceptedMu.Lock()
+	defer t.acceptedMu.Unlock()
+
+	t.accepted[addr] = names
+}
+
+// pick returns the first of our encodings that is in names
+func (t *compressTransport) pick(names []string) (string, bool) {
+	for _, ours := range t.opts.Encodings {
+		for _, name := range names {
+			if ours == name {
+				return ours, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+type compressListener struct {
+	transport.Listener
+	t *compressTransport
+}
+
+func (l *compressListener) Accept(ctx context.Context, fn func(transport.Socket)) error {
+	return l.Listener.Accept(ctx, func(s transport.Socket) {
+		fn(&compressSocket{Socket: s, t: l.t})
+	})
+}
+
+type compressSocket struct {
+	transport.Socket
+	t *compressTransport
+
+	// addr is the address that the socket was dialed to, or empty if it was accepted by a listener
+	addr string
+
+	// peerAccepted holds the encodings accepted by the other side of an accepted socket
+	peerAccepted   []string
+	peerAcceptedMu sync.Mutex
+}
+
+func (s *compressSocket) Send(ctx context.Context, msg *transport.Message) error {
+	// Don't modify the caller's message
+	out := *msg
+	out.MessageHeaders = make(transport.MessageHeaders, len(msg.MessageHeaders)+2)
+	for k, v := range msg.MessageHeaders {
+		out.MessageHeaders[k] = v
+	}
+
+	out.SetAcceptEncoding(s.t.opts.Encodings)
+
+	if len(out.Data) >= s.t.opts.Threshold {
+		if name, ok := s.t.pick(s.getPeerAccepted()); ok {
+			data, err := encodings[name].compress(out.Data)
+			if err != nil {
+				return fmt.Errorf("compress data: %w", err)
+			}
+
+			// Some data doesn't get any smaller, in which case it's not worth making the other side decompress it
+			if len(data) < len(out.Data) {
+				out.Data = data
+				out.SetEncoding(name)
+			}
+		}
+	}
+
+	return s.Socket.Send(ctx, &out)
+}
+
+func (s *compressSocket) Receive(ctx context.Context, msg *transport.Message) error {
+	if err := s.Socket.Receive(ctx, msg); err != nil {
+		return err
+	}
+
+	if names, ok := msg.GetAcceptEncoding(); ok {
+		s.setPeerAccepted(names)
+		delete(msg.MessageHeaders, transport.HeaderAcceptEncoding)
+	}
+
+	if name, ok := msg.GetEncoding(); ok {
+		enc, ok := encodings[name]
+		if !ok {
+			return fmt.Errorf("decompress data: %w: %s", ErrUnknownEncoding, name)
+		}
+
+		data, err := enc.decompress(msg.Data, s.t.opts.MaxSize)
+		if err != nil {
+			return fmt.Errorf("decompress data: %w", err)
+		}
+
+		msg.Data = data
+		delete(msg.MessageHeaders, transport.HeaderEncoding)
+	}
+
+	return nil
+}
+
+func (s *compressSocket) getPeerAccepted() []string {
+	if s.addr != "" {
+		return s.t.getAccepted(s.addr)
+	}
+
+	s.peerAcceptedMu.Lock()
+	defer s.peerAcceptedMu.Unlock()
+
+	return s.peerAccepted
+}
+
+// setPeerAccepted remembers the encodings accepted by the other side. For dialed sockets they're kept by the transport,
+// so that other sockets to the same address can compress their first message.
+func (s *compressSocket) setPeerAccepted(names []string) {
+	if s.addr != "" {
+		s.t.setAccepted(s.addr, names)
+		return
+	}
+
+	s.peerAcceptedMu.Lock()
+	defer s.peerAcceptedMu.Unlock()
+
+	s.peerAccepted = names
+}
@@ -0,0 +1,138 @@
+package compress
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/MouseHatGames/mice/logger/stdout"
+	"github.com/MouseHatGames/mice/options"
+	"github.com/MouseHatGames/mice/transport"
+	"github.com/MouseHatGames/mice/transport/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingTransport records the encoding of the messages that go through the sockets it dials
+type recordingTransport struct {
+	transport.Transport
+
+	mu       sync.Mutex
+	sent     []string
+	received []string
+}
+
+func (t *recordingTransport) Dial(ctx context.Context, addr string) (transport.Socket, error) {
+	s, err := t.Transport.Dial(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	return &recordingSocket{s, t}, nil
+}
+
+type recordingSocket struct {
+	transport.Socket
+	t *recordingTransport
+}
+
+func (s *recordingSocket) Send(ctx context.Context, msg *transport.Message) error {
+	name, _ := msg.GetEncoding()
+
+	s.t.mu.Lock()
+	s.t.sent = append(s.t.sent, name)
+	s.t.mu.Unlock()
+
+	return s.Socket.Send(ctx, msg)
+}
+
+func (s *recordingSocket) Receive(ctx context.Context, msg *transport.Message) error {
+	if err := s.Socket.Receive(ctx, msg); err != nil {
+		return err
+	}
+
+	name, _ := msg.GetEncoding()
+
+	s.t.mu.Lock()
+	s.t.received = append(s.t.received, name)
+	s.t.mu.Unlock()
+
+	return nil
+}
+
+// newTransport returns a compressing transport on top of a recording memory transport, with an echo listener on "echo:1"
+func newTransport(t *testing.T, opts ...Option) (transport.Transport, *recordingTransport) {
+	o := &options.Options{Logger: stdout.NewStdoutLogger(" ")}
+	memory.Transport(memory.WithNetwork(memory.NewNetwork()))(o)
+
+	rec := &recordingTransport{Transport: o.Transport}
+	o.Transport = rec
+	Compression(opts...)(o)
+
+	l, err := o.Transport.Listen(context.Background(), "echo:1")
+	require.Nil(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	go l.Accept(context.Background(), func(soc transport.Socket) {
+		go func() {
+			defer soc.Close()
+
+			for {
+				var msg transport.Message
+				if err := soc.Receive(context.Background(), &msg); err != nil {
+					return
+				}
+				soc.Send(context.Background(), &msg)
+			}
+		}()
+	})
+
+	return o.Transport, rec
+}
+
+func roundTrip(t *testing.T, tr transport.Transport, data []byte) *transport.Message {
+	soc, err := tr.Dial(context.Background(), "echo:1")
+	require.Nil(t, err)
+	defer soc.Close()
+
+	req := transport.NewMessage()
+	req.Data = data
+	require.Nil(t, soc.Send(context.Background(), req))
+
+	_, ok := req.GetEncoding()
+	assert.False(t, ok, "the caller's message isn't modified")
+
+	var resp transport.Message
+	require.Nil(t, soc.Receive(context.Background(), &resp))
+
+	return &resp
+}
+
+func TestNegotiation(t *testing.T) {
+	tr, rec := newTransport(t, Threshold(100))
+	data := bytes.Repeat([]byte("mice "), 1000)
+
+	resp := roundTrip(t, tr, data)
+	assert.Equal(t, data, resp.Data)
+
+	_, ok := resp.GetEncoding()
+	assert.False(t, ok, "encoding headers are removed once the data is decompressed")
+
+	resp = roundTrip(t, tr, data)
+	assert.Equal(t, data, resp.Data)
+
+	resp = roundTrip(t, tr, []byte("small"))
+	assert.Equal(t, []byte("small"), resp.Data)
+
+	// The first request is sent uncompressed since the other side hasn't told us what it accepts yet
+	assert.Equal(t, []string{"", EncodingZstd, ""}, rec.sent)
+	assert.Equal(t, []string{EncodingZstd, EncodingZstd, ""}, rec.received)
+}
+
+func TestEncodingPreference(t *testing.T) {
+	tr, rec := newTransport(t, Threshold(0), Encodings(EncodingGzip, EncodingSnappy))
+	data := bytes.Repeat([]byte("mice "), 1000)
+
+	roundTrip(t, tr, data)
+	assert.Equal(t, []string{EncodingGzip}, rec.received)
+}
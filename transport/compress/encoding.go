@@ -0,0 +1,122 @@
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	EncodingGzip   = "gzip"
+	EncodingZstd   = "zstd"
+	EncodingSnappy = "snappy"
+)
+
+var ErrUnknownEncoding = errors.New("unknown encoding")
+var ErrTooLarge = errors.New("decompressed data is too large")
+
+// encoding compresses and decompresses data with an algorithm
+type encoding interface {
+	compress(b []byte) ([]byte, error)
+
+	// decompress decompresses b, failing with ErrTooLarge if the result would be larger than max bytes
+	decompress(b []byte, max int) ([]byte, error)
+}
+
+var encodings = map[string]encoding{
+	EncodingGzip:   gzipEncoding{},
+	EncodingZstd:   zstdEncoding{},
+	EncodingSnappy: snappyEncoding{},
+}
+
+type gzipEncoding struct{}
+
+func (gzipEncoding) compress(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (gzipEncoding) decompress(b []byte, max int) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	// Read one byte more than allowed to find out if the data is too large
+	out, err := io.ReadAll(io.LimitReader(r, int64(max)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > max {
+		return nil, ErrTooLarge
+	}
+
+	return out, nil
+}
+
+// The zstd encoder can be used concurrently as long as only EncodeAll is called
+var zstdEncoder, _ = zstd.NewWriter(nil)
+
+type zstdEncoding struct{}
+
+func (zstdEncoding) compress(b []byte) ([]byte, error) {
+	return zstdEncoder.EncodeAll(b, nil), nil
+}
+
+func (zstdEncoding) decompress(b []byte, max int) ([]byte, error) {
+	// Frames that declare their size can be rejected without decompressing anything
+	var frame zstd.Header
+	if err := frame.Decode(b); err == nil && frame.HasFCS && frame.FrameContentSize > uint64(max) {
+		return nil, ErrTooLarge
+	}
+
+	// Not every frame declares its size, so the data is streamed like gzip's instead of decoded all at once
+	r, err := zstd.NewReader(bytes.NewReader(b), zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	// Read one byte more than allowed to find out if the data is too large
+	out, err := io.ReadAll(io.LimitReader(r, int64(max)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > max {
+		return nil, ErrTooLarge
+	}
+
+	return out, nil
+}
+
+type snappyEncoding struct{}
+
+func (snappyEncoding) compress(b []byte) ([]byte, error) {
+	return snappy.Encode(nil, b), nil
+}
+
+func (snappyEncoding) decompress(b []byte, max int) ([]byte, error) {
+	n, err := snappy.DecodedLen(b)
+	if err != nil {
+		return nil, err
+	}
+	if n > max {
+		return nil, ErrTooLarge
+	}
+
+	return snappy.Decode(nil, b)
+}
@@ -0,0 +1,55 @@
+package compress
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodings(t *testing.T) {
+	data := bytes.Repeat([]byte("mice "), 1000)
+
+	for name, enc := range encodings {
+		t.Run(name, func(t *testing.T) {
+			compressed, err := enc.compress(data)
+			require.Nil(t, err)
+			assert.Less(t, len(compressed), len(data))
+
+			out, err := enc.decompress(compressed, len(data))
+			require.Nil(t, err)
+			assert.Equal(t, data, out)
+
+			_, err = enc.decompress(compressed, len(data)-1)
+			assert.ErrorIs(t, err, ErrTooLarge)
+		})
+	}
+}
+
+func TestZstdWithoutContentSize(t *testing.T) {
+	data := bytes.Repeat([]byte("mice "), 1000)
+
+	// Streaming encoders don't know the size of the data if the frame header is written before it's all been written
+	var buf bytes.Buffer
+	w, err := zstd.NewWriter(&buf)
+	require.Nil(t, err)
+	_, err = w.Write(data)
+	require.Nil(t, err)
+	require.Nil(t, w.Flush())
+	require.Nil(t, w.Close())
+
+	var frame zstd.Header
+	require.Nil(t, frame.Decode(buf.Bytes()))
+	require.False(t, frame.HasFCS)
+
+	enc := encodings[EncodingZstd]
+
+	out, err := enc.decompress(buf.Bytes(), len(data))
+	require.Nil(t, err)
+	assert.Equal(t, data, out)
+
+	_, err = enc.decompress(buf.Bytes(), len(data)-1)
+	assert.ErrorIs(t, err, ErrTooLarge)
+}
@@ -0,0 +1,47 @@
+package compress
+
+// Options holds the configuration of payload compression
+type Options struct {
+	// Threshold is the minimum size in bytes that a message's data must have to be compressed
+	Threshold int
+
+	// Encodings contains the names of the encodings that can be used, in order of preference
+	Encodings []string
+
+	// MaxSize is the maximum size in bytes that compressed data can have once it's decompressed
+	MaxSize int
+}
+
+// Option represents a function that can be used to mutate an Options object
+type Option func(*Options)
+
+func defaultOptions() Options {
+	return Options{
+		Threshold: 1024,
+		Encodings: []string{EncodingZstd, EncodingSnappy, EncodingGzip},
+		MaxSize:   32 << 20,
+	}
+}
+
+// Threshold sets the minimum size in bytes that a message's data must have to be compressed, since compressing
+// small payloads usually takes more time than it saves. Defaults to 1 KiB
+func Threshold(n int) Option {
+	return func(o *Options) {
+		o.Threshold = n
+	}
+}
+
+// Encodings sets the encodings that can be used, in order of preference. Defaults to zstd, snappy and gzip
+func Encodings(names ...string) Option {
+	return func(o *Options) {
+		o.Encodings = names
+	}
+}
+
+// MaxSize sets the maximum size in bytes that compressed data can have once it's decompressed, which protects
+// against decompression bombs. Defaults to 32 MiB
+func MaxSize(n int) Option {
+	return func(o *Options) {
+		o.MaxSize = n
+	}
+}
@@ -0,0 +1,88 @@
+package transport
+
+import (
+	"context"
+	"sync"
+)
+
+// StreamWindow is the number of data messages that each side of a stream can send before the other side acknowledges
+// them. Receivers buffer up to this many messages, so a slow receiver only blocks its own stream instead of the
+// connection that it shares with other requests.
+const StreamWindow = 16
+
+// StreamFlow implements the flow control of one side of a stream. Sending a data message takes a credit, and the
+// receiver returns credits in StreamAck messages as the messages it has received are consumed.
+type StreamFlow struct {
+	credits  int
+	consumed int
+	mu       sync.Mutex
+
+	// granted is signalled when credits are returned, waking up a sender
+	granted chan struct{}
+}
+
+func NewStreamFlow() *StreamFlow {
+	return &StreamFlow{
+		credits: StreamWindow,
+		granted: make(chan struct{}, 1),
+	}
+}
+
+// Acquire takes a credit to send a data message, waiting until the other side returns one if there are none left
+func (f *StreamFlow) Acquire(ctx context.Context) error {
+	for {
+		f.mu.Lock()
+		if f.credits > 0 {
+			f.credits--
+			left := f.credits
+			f.mu.Unlock()
+
+			// Let other senders know that there are still credits left
+			if left > 0 {
+				f.signal()
+			}
+			return nil
+		}
+		f.mu.Unlock()
+
+		select {
+		case <-f.granted:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Grant returns n credits, which is done when receiving a StreamAck message
+func (f *StreamFlow) Grant(n int) {
+	f.mu.Lock()
+	f.credits += n
+	f.mu.Unlock()
+
+	f.signal()
+}
+
+// Consume is called when a received data message has been consumed. It returns the number of credits that should be
+// returned to the other side in a StreamAck message now, or 0 if it's not worth sending one yet.
+func (f *StreamFlow) Consume() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.consumed++
+
+	// Acknowledge messages in batches to avoid sending an acknowledgement for every message
+	if f.consumed < StreamWindow/2 {
+		return 0
+	}
+
+	n := f.consumed
+	f.consumed = 0
+	return n
+}
+
+func (f *StreamFlow) signal() {
+	select {
+	case f.granted <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,35 @@
+package transport
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStreamFlow(t *testing.T) {
+	f := NewStreamFlow()
+
+	for i := 0; i < StreamWindow; i++ {
+		assert.Nil(t, f.Acquire(context.Background()))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	assert.Equal(t, context.DeadlineExceeded, f.Acquire(ctx), "there are no credits left")
+
+	acquired := make(chan error)
+	go func() {
+		acquired <- f.Acquire(context.Background())
+	}()
+
+	f.Grant(1)
+	assert.Nil(t, <-acquired)
+
+	for i := 1; i < StreamWindow/2; i++ {
+		assert.Equal(t, 0, f.Consume())
+	}
+	assert.Equal(t, StreamWindow/2, f.Consume(), "messages are acknowledged in batches")
+}
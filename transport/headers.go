@@ -3,6 +3,8 @@ package transport
 import (
 	goerrors "errors"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/MouseHatGames/mice/errors"
 	"github.com/google/uuid"
@@ -14,6 +16,34 @@ const (
 	HeaderRequestID       = "reqid"
 	HeaderParentRequestID = "parentreq"
 	HeaderUserID          = "userid"
+	HeaderTimeout         = "timeout"
+	HeaderStream          = "stream"
+	HeaderContentType     = "content-type"
+	HeaderAccept          = "accept"
+	HeaderEncoding        = "encoding"
+	HeaderAcceptEncoding  = "accept-encoding"
+	HeaderCredit          = "credit"
+)
+
+// Values of the stream header, which is set on all messages that belong to a stream. The request ID of these
+// messages is the ID of the stream.
+const (
+	// StreamOpen is sent by the client to open a stream to the endpoint in the path header
+	StreamOpen = "open"
+
+	// StreamData is sent by either side with a message in its data
+	StreamData = "data"
+
+	// StreamClose is sent by the client when it won't send any more messages, and by the server when the handler
+	// has returned, along with its error if there's one
+	StreamClose = "close"
+
+	// StreamCancel is sent by the client when it abandons the stream
+	StreamCancel = "cancel"
+
+	// StreamAck is sent by either side to let the other one send as many more messages as the credit header says,
+	// see StreamFlow
+	StreamAck = "ack"
 )
 
 type MessageHeaders map[string]string
@@ -130,3 +160,121 @@ func (h MessageHeaders) GetUserID() (id uint32, hasID bool) {
 
 	return uint32(id64), true
 }
+
+// SetTimeout sets the time that the caller is willing to wait for a response, rounded up to the millisecond
+func (h *MessageHeaders) SetTimeout(d time.Duration) {
+	ms := (d + time.Millisecond - 1) / time.Millisecond
+	if ms < 1 {
+		ms = 1
+	}
+
+	h.ensure()[HeaderTimeout] = strconv.FormatInt(int64(ms), 10)
+}
+
+func (h MessageHeaders) GetTimeout() (d time.Duration, hasTimeout bool) {
+	msStr, ok := h[HeaderTimeout]
+	if !ok {
+		return 0, false
+	}
+
+	ms, err := strconv.ParseInt(msStr, 10, 64)
+	if err != nil || ms <= 0 {
+		return 0, false
+	}
+
+	return time.Duration(ms) * time.Millisecond, true
+}
+
+func (h MessageHeaders) GetStream() (kind string, isStream bool) {
+	kind, isStream = h[HeaderStream]
+	return
+}
+
+func (h *MessageHeaders) SetStream(kind string) {
+	h.ensure()[HeaderStream] = kind
+}
+
+// GetCredit returns the number of messages that a StreamAck message allows the receiver to send
+func (h MessageHeaders) GetCredit() (n int, hasCredit bool) {
+	nStr, ok := h[HeaderCredit]
+	if !ok {
+		return 0, false
+	}
+
+	n, err := strconv.Atoi(nStr)
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+
+	return n, true
+}
+
+func (h *MessageHeaders) SetCredit(n int) {
+	h.ensure()[HeaderCredit] = strconv.Itoa(n)
+}
+
+func (h MessageHeaders) GetContentType() (contentType string, hasContentType bool) {
+	contentType, hasContentType = h[HeaderContentType]
+	return
+}
+
+// SetContentType sets the content type of the message's data, or removes it if contentType is empty
+func (h *MessageHeaders) SetContentType(contentType string) {
+	if contentType == "" {
+		delete(*h, HeaderContentType)
+		return
+	}
+
+	h.ensure()[HeaderContentType] = contentType
+}
+
+// GetAccept returns the content types that the sender of the message can decode, in order of preference
+func (h MessageHeaders) GetAccept() []string {
+	value, ok := h[HeaderAccept]
+	if !ok || value == "" {
+		return nil
+	}
+
+	return splitList(value)
+}
+
+func (h *MessageHeaders) SetAccept(contentTypes []string) {
+	h.ensure()[HeaderAccept] = strings.Join(contentTypes, ",")
+}
+
+// GetEncoding returns the name of the compression that has been applied to the message's data
+func (h MessageHeaders) GetEncoding() (name string, hasEncoding bool) {
+	name, hasEncoding = h[HeaderEncoding]
+	return
+}
+
+func (h *MessageHeaders) SetEncoding(name string) {
+	h.ensure()[HeaderEncoding] = name
+}
+
+// GetAcceptEncoding returns the compressions that the sender of the message can decompress, in order of preference
+func (h MessageHeaders) GetAcceptEncoding() (names []string, hasAcceptEncoding bool) {
+	value, ok := h[HeaderAcceptEncoding]
+	if !ok {
+		return nil, false
+	}
+
+	return splitList(value), true
+}
+
+func (h *MessageHeaders) SetAcceptEncoding(names []string) {
+	h.ensure()[HeaderAcceptEncoding] = strings.Join(names, ",")
+}
+
+// splitList splits a comma-separated header value, trimming its entries and leaving out the empty ones
+func splitList(value string) []string {
+	var entries []string
+
+	for _, e := range strings.Split(value, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			entries = append(entries, e)
+		}
+	}
+
+	return entries
+}
@@ -0,0 +1,51 @@
+package transport
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetAccept(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []string
+	}{
+		{"application/json", []string{"application/json"}},
+		{"application/msgpack, application/json", []string{"application/msgpack", "application/json"}},
+		{" application/json ,, ", []string{"application/json"}},
+		{"", nil},
+	}
+
+	for _, tt := range tests {
+		h := MessageHeaders{HeaderAccept: tt.value}
+		assert.Equal(t, tt.want, h.GetAccept(), "value %q", tt.value)
+	}
+
+	assert.Nil(t, MessageHeaders{}.GetAccept())
+}
+
+func TestGetAcceptEncoding(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []string
+	}{
+		{"zstd", []string{"zstd"}},
+		{"zstd,gzip", []string{"zstd", "gzip"}},
+		{"zstd, gzip", []string{"zstd", "gzip"}},
+		{" gzip ,,zstd, ", []string{"gzip", "zstd"}},
+		{",", nil},
+		{"", nil},
+	}
+
+	for _, tt := range tests {
+		h := MessageHeaders{HeaderAcceptEncoding: tt.value}
+
+		names, ok := h.GetAcceptEncoding()
+		assert.True(t, ok, "value %q", tt.value)
+		assert.Equal(t, tt.want, names, "value %q", tt.value)
+	}
+
+	_, ok := MessageHeaders{}.GetAcceptEncoding()
+	assert.False(t, ok)
+}
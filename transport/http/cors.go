@@ -0,0 +1,144 @@
+package http
+
+import (
+	goerrors "errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MouseHatGames/mice/transport"
+)
+
+// ErrWildcardCredentials is the reason why a CORS policy that allows credentials from any origin is rejected, since
+// that would let any website make requests on behalf of its visitors
+var ErrWildcardCredentials = goerrors.New("credentials can't be allowed for any origin")
+
+// CORSPolicy describes which browser origins are allowed to make requests to the transport's endpoints
+type CORSPolicy struct {
+	// AllowedOrigins contains the origins that can make requests, or "*" to allow any origin
+	AllowedOrigins []string
+
+	// AllowedHeaders contains the request headers that can be sent, or "*" to allow any header
+	AllowedHeaders []string
+
+	// ExposedHeaders contains the response headers that browsers let scripts read.
+	// DefaultExposedHeaders are exposed if it's nil
+	ExposedHeaders []string
+
+	// AllowCredentials allows browsers to send cookies and authorization headers. AllowedOrigins can't contain "*" if it's set
+	AllowCredentials bool
+
+	// MaxAge is how long the result of a preflight request can be cached for. It's not sent if it's 0
+	MaxAge time.Duration
+}
+
+// DefaultExposedHeaders are the response headers written by the transport that scripts may want to read, like the
+// request ID and the hint to retry later
+var DefaultExposedHeaders = []string{
+	miceHeader(transport.HeaderRequestID),
+	miceHeader(transport.HeaderError),
+	miceHeader(transport.HeaderContentType),
+	miceHeader(transport.HeaderAccept),
+	miceHeader(transport.HeaderEncoding),
+	miceHeader(transport.HeaderAcceptEncoding),
+	"Retry-After",
+}
+
+// allowedMethods are the methods that the request endpoint accepts
+var allowedMethods = strings.Join([]string{http.MethodPost, http.MethodOptions}, ", ")
+
+// DefaultCORSPolicy returns a policy that allows requests from any origin with any header
+func DefaultCORSPolicy() *CORSPolicy {
+	return &CORSPolicy{
+		AllowedOrigins: []string{"*"},
+		AllowedHeaders: []string{"*"},
+	}
+}
+
+// validate returns an error if the policy can't be applied safely
+func (p *CORSPolicy) validate() error {
+	if p.AllowCredentials && p.allowsAnyOrigin() {
+		return ErrWildcardCredentials
+	}
+	return nil
+}
+
+func (p *CORSPolicy) allowsAnyOrigin() bool {
+	for _, o := range p.AllowedOrigins {
+		if o == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *CORSPolicy) allowsOrigin(origin string) bool {
+	for _, o := range p.AllowedOrigins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *CORSPolicy) allowsAnyHeader() bool {
+	for _, h := range p.AllowedHeaders {
+		if h == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// apply writes the CORS response headers for the request, if it comes from an allowed origin
+func (p *CORSPolicy) apply(rw http.ResponseWriter, r *http.Request) {
+	h := rw.Header()
+	h.Add("Vary", "Origin")
+
+	origin := r.Header.Get("Origin")
+	if origin == "" || !p.allowsOrigin(origin) {
+		return
+	}
+
+	// Policies that allow any origin never allow credentials, see validate
+	if p.allowsAnyOrigin() {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Set("Access-Control-Allow-Origin", origin)
+	}
+
+	if p.AllowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+
+	if r.Method != http.MethodOptions {
+		exposed := p.ExposedHeaders
+		if exposed == nil {
+			exposed = DefaultExposedHeaders
+		}
+
+		if len(exposed) > 0 {
+			h.Set("Access-Control-Expose-Headers", strings.Join(exposed, ", "))
+		}
+		return
+	}
+
+	h.Set("Access-Control-Allow-Methods", allowedMethods)
+
+	if p.allowsAnyHeader() {
+		if p.AllowCredentials {
+			if req := r.Header.Get("Access-Control-Request-Headers"); req != "" {
+				h.Set("Access-Control-Allow-Headers", req)
+			}
+		} else {
+			h.Set("Access-Control-Allow-Headers", "*")
+		}
+	} else if len(p.AllowedHeaders) > 0 {
+		h.Set("Access-Control-Allow-Headers", strings.Join(p.AllowedHeaders, ", "))
+	}
+
+	if p.MaxAge > 0 {
+		h.Set("Access-Control-Max-Age", strconv.Itoa(int(p.MaxAge/time.Second)))
+	}
+}
@@ -0,0 +1,101 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCORSPolicy(t *testing.T) {
+	p := &CORSPolicy{
+		AllowedOrigins:   []string{"https://dashboard.example.com"},
+		AllowedHeaders:   []string{"Content-Type"},
+		AllowCredentials: true,
+		MaxAge:           time.Hour,
+	}
+
+	t.Run("allowed origin", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodOptions, "/request", nil)
+		r.Header.Set("Origin", "https://dashboard.example.com")
+		rec := httptest.NewRecorder()
+
+		p.apply(rec, r)
+
+		h := rec.Header()
+		assert.Equal(t, "https://dashboard.example.com", h.Get("Access-Control-Allow-Origin"))
+		assert.Equal(t, "true", h.Get("Access-Control-Allow-Credentials"))
+		assert.Equal(t, "Content-Type", h.Get("Access-Control-Allow-Headers"))
+		assert.Equal(t, "3600", h.Get("Access-Control-Max-Age"))
+	})
+	t.Run("exposed headers", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodPost, "/request", nil)
+		r.Header.Set("Origin", "https://dashboard.example.com")
+		rec := httptest.NewRecorder()
+
+		p.apply(rec, r)
+
+		exposed := rec.Header().Get("Access-Control-Expose-Headers")
+		assert.Contains(t, exposed, "X-Mice-Reqid")
+		assert.Contains(t, exposed, "Retry-After")
+
+		custom := &CORSPolicy{AllowedOrigins: []string{"*"}, ExposedHeaders: []string{"X-Mice-Reqid"}}
+		rec = httptest.NewRecorder()
+
+		custom.apply(rec, r)
+
+		assert.Equal(t, "X-Mice-Reqid", rec.Header().Get("Access-Control-Expose-Headers"))
+	})
+	t.Run("disallowed origin", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodOptions, "/request", nil)
+		r.Header.Set("Origin", "https://evil.example.com")
+		rec := httptest.NewRecorder()
+
+		p.apply(rec, r)
+
+		assert.Empty(t, rec.Header().Get("Access-Control-Allow-Origin"))
+	})
+}
+
+func TestCORSWildcardCredentials(t *testing.T) {
+	assert.Panics(t, func() {
+		newTransport(CORS(&CORSPolicy{AllowedOrigins: []string{"*"}, AllowCredentials: true}))
+	})
+	assert.Panics(t, func() {
+		newTransport(CORS(&CORSPolicy{AllowedOrigins: []string{"https://dashboard.example.com", "*"}, AllowCredentials: true}))
+	}, "the wildcard allows any origin wherever it is")
+
+	assert.NotPanics(t, func() {
+		newTransport(CORS(&CORSPolicy{AllowedOrigins: []string{"https://dashboard.example.com"}, AllowCredentials: true}))
+	})
+	assert.NotPanics(t, func() {
+		newTransport(CORS(&CORSPolicy{AllowedOrigins: []string{"*"}}))
+	})
+}
+
+func TestRequestMethods(t *testing.T) {
+	addr := listenEcho(t, newTransport())
+	url := "http://" + addr + "/request"
+
+	req, _ := http.NewRequest(http.MethodOptions, url, nil)
+	req.Header.Set("Origin", "https://dashboard.example.com")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+
+	resp, err := http.DefaultClient.Do(req)
+	require.Nil(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.Equal(t, "*", resp.Header.Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, allowedMethods, resp.Header.Get("Access-Control-Allow-Methods"))
+
+	resp, err = http.Get(url)
+	require.Nil(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+	assert.Equal(t, allowedMethods, resp.Header.Get("Allow"))
+}
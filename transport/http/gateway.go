@@ -0,0 +1,219 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	goerrors "errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/MouseHatGames/mice/errors"
+	"github.com/MouseHatGames/mice/logger"
+	"github.com/MouseHatGames/mice/tracing"
+	"github.com/MouseHatGames/mice/transport"
+)
+
+var ErrInvalidGatewayPath = goerrors.New("path must be in the /{handler}/{method} format")
+
+// gatewayIgnoredHeaders are the message headers that browsers and third parties can't set, since the server trusts
+// them to come from other services
+var gatewayIgnoredHeaders = []string{
+	transport.HeaderUserID,
+	transport.HeaderParentRequestID,
+	transport.HeaderTimeout,
+	transport.HeaderStream,
+	transport.HeaderEncoding,
+	transport.HeaderAcceptEncoding,
+}
+
+// gatewayResponseHeader returns whether a response header can be shown to browsers and third parties. Errors are
+// sent in the body, and the headers that other services trust or that only matter between services are left out
+func gatewayResponseHeader(name string) bool {
+	if name == transport.HeaderError || name == transport.HeaderCredit || strings.HasPrefix(name, tracing.HeaderPrefix) {
+		return false
+	}
+
+	for _, h := range gatewayIgnoredHeaders {
+		if name == h {
+			return false
+		}
+	}
+
+	return true
+}
+
+// httpGatewaySocket exposes an endpoint as a plain HTTP request, taking the endpoint from the URL instead of a header
+// and returning errors in the body
+type httpGatewaySocket struct {
+	rw              http.ResponseWriter
+	r               *http.Request
+	log             logger.Logger
+	closer          chan<- struct{}
+	sentResponse    bool
+	receivedRequest bool
+}
+
+var _ transport.Socket = (*httpGatewaySocket)(nil)
+
+// gatewayError is the body of a gateway response that contains an error
+type gatewayError struct {
+	Status int16  `json:"status"`
+	ID     string `json:"id,omitempty"`
+	Detail string `json:"detail"`
+}
+
+func (s *httpGatewaySocket) Close() error {
+	s.log.Debugf("closing gateway socket")
+
+	// Requests that were rejected before being passed on to the server still need a response
+	if !s.sentResponse {
+		s.writeError(errors.NotFound(ErrInvalidGatewayPath.Error()))
+	}
+
+	s.closer <- struct{}{}
+	return nil
+}
+
+func (s *httpGatewaySocket) Send(ctx context.Context, msg *transport.Message) error {
+	if s.sentResponse {
+		return goerrors.New("response already sent")
+	}
+
+	s.log.Debugf("sending gateway response with %d bytes", len(msg.Data))
+
+	h := s.rw.Header()
+	for k, v := range msg.MessageHeaders {
+		if gatewayResponseHeader(k) {
+			h.Set(headerPrefix+k, headerValue(v))
+		}
+	}
+
+	if err, ok := msg.GetError(); ok {
+		return s.writeError(err)
+	}
+
+	if ct, ok := msg.GetContentType(); ok {
+		h.Set("Content-Type", ct)
+	}
+
+	s.sentResponse = true
+
+	if _, err := s.rw.Write(msg.Data); err != nil {
+		return fmt.Errorf("write body: %w", err)
+	}
+
+	return nil
+}
+
+func (s *httpGatewaySocket) writeError(err error) error {
+	s.sentResponse = true
+
+	status := errorStatus(err)
+
+	body := gatewayError{
+		Status: int16(status),
+		Detail: err.Error(),
+	}
+
+	if merr, ok := err.(*errors.Error); ok {
+		body.ID = merr.ID
+		body.Detail = merr.Detail
+	}
+
+	s.rw.Header().Set("Content-Type", "application/json")
+	writeErrorHeader(s.rw, err)
+
+	if err := json.NewEncoder(s.rw).Encode(&body); err != nil {
+		return fmt.Errorf("encode error: %w", err)
+	}
+
+	return nil
+}
+
+func (s *httpGatewaySocket) Receive(ctx context.Context, msg *transport.Message) error {
+	if s.receivedRequest {
+		return io.EOF
+	}
+	s.receivedRequest = true
+
+	path, ok := gatewayPath(s.r.URL.Path)
+	if !ok {
+		return io.EOF
+	}
+
+	data, err := io.ReadAll(s.r.Body)
+	if err != nil {
+		return fmt.Errorf("read body: %w", err)
+	}
+
+	msg.MessageHeaders = getMiceHeaders(s.r.Header)
+	for _, h := range gatewayIgnoredHeaders {
+		delete(msg.MessageHeaders, h)
+	}
+
+	// Plain HTTP clients use the standard headers to tell what they're sending and what they accept
+	if _, ok := msg.GetContentType(); !ok {
+		if ct, ok := mediaType(s.r.Header.Get("Content-Type")); ok {
+			msg.SetContentType(ct)
+		}
+	}
+	if _, ok := msg.MessageHeaders[transport.HeaderAccept]; !ok {
+		if types := acceptedTypes(s.r.Header.Values("Accept")); len(types) > 0 {
+			msg.SetAccept(types)
+		}
+	}
+
+	msg.SetPath(path)
+	msg.Data = data
+
+	if _, ok := msg.GetRequestID(); !ok {
+		msg.SetRandomRequestID()
+	}
+
+	s.log.Debugf("received gateway request to %s with %d bytes", path, len(msg.Data))
+
+	return nil
+}
+
+// gatewayPath converts an URL path in the /{handler}/{method} format to an endpoint path
+func gatewayPath(urlPath string) (string, bool) {
+	parts := strings.Split(strings.Trim(urlPath, "/"), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", false
+	}
+
+	return parts[0] + "." + parts[1], true
+}
+
+// mediaType returns the media type in the value of a Content-Type header, without its parameters
+func mediaType(value string) (string, bool) {
+	if value == "" {
+		return "", false
+	}
+
+	mt, _, err := mime.ParseMediaType(value)
+	if err != nil {
+		return "", false
+	}
+
+	return mt, true
+}
+
+// acceptedTypes returns the media types listed in the values of an Accept header, leaving out wildcards since any
+// codec will do for those
+func acceptedTypes(values []string) []string {
+	var types []string
+
+	for _, value := range values {
+		for _, part := range strings.Split(value, ",") {
+			if mt, ok := mediaType(strings.TrimSpace(part)); ok && !strings.Contains(mt, "*") {
+				types = append(types, mt)
+			}
+		}
+	}
+
+	return types
+}
@@ -0,0 +1,300 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	gojson "encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/MouseHatGames/mice/auth"
+	"github.com/MouseHatGames/mice/codec/json"
+	"github.com/MouseHatGames/mice/codec/msgpack"
+	"github.com/MouseHatGames/mice/errors"
+	"github.com/MouseHatGames/mice/logger/stdout"
+	"github.com/MouseHatGames/mice/options"
+	"github.com/MouseHatGames/mice/server/router"
+	"github.com/MouseHatGames/mice/tracing"
+	"github.com/MouseHatGames/mice/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// listenGateway starts a gateway listener that echoes requests to echo.Echo and fails the rest with a 404 error,
+// returning the gateway's URL
+func listenGateway(t *testing.T) string {
+	l, err := newTransport(Gateway("127.0.0.1:0")).Listen(context.Background(), "127.0.0.1:0")
+	require.Nil(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	go l.Accept(context.Background(), func(soc transport.Socket) {
+		go func() {
+			defer soc.Close()
+
+			var req transport.Message
+			if err := soc.Receive(context.Background(), &req); err != nil {
+				return
+			}
+
+			resp := transport.NewMessage()
+			resp.SetRequestID(req.MustGetRequestID())
+
+			switch path, _ := req.GetPath(); path {
+			case "echo.Echo":
+				resp.Data = req.Data
+				resp.MessageHeaders["user"] = req.MessageHeaders["user"]
+			case "echo.Internal":
+				resp.MessageHeaders[transport.HeaderParentRequestID] = "parent"
+				resp.MessageHeaders[transport.HeaderUserID] = "42"
+				resp.MessageHeaders[transport.HeaderCredit] = "8"
+				resp.MessageHeaders[tracing.HeaderPrefix+"traceparent"] = "00-trace"
+				resp.MessageHeaders["note"] = "line\r\nX-Injected: 1"
+			default:
+				resp.SetError(errors.NotFoundID("missing", "no such thing"))
+			}
+
+			soc.Send(context.Background(), resp)
+		}()
+	})
+
+	return "http://" + l.(*httpListener).gatewayLn.Addr().String()
+}
+
+func TestGateway(t *testing.T) {
+	url := listenGateway(t)
+
+	t.Run("success", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodPost, url+"/echo/Echo", bytes.NewReader([]byte(`{"a":1}`)))
+		req.Header.Set("X-Mice-User", "123")
+
+		resp, err := http.DefaultClient.Do(req)
+		require.Nil(t, err)
+		defer resp.Body.Close()
+
+		body, _ := io.ReadAll(resp.Body)
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, `{"a":1}`, string(body))
+		assert.Equal(t, "123", resp.Header.Get("X-Mice-User"))
+		assert.NotEmpty(t, resp.Header.Get("X-Mice-Reqid"))
+	})
+	t.Run("error", func(t *testing.T) {
+		resp, err := http.Post(url+"/echo/Fail", "application/json", nil)
+		require.Nil(t, err)
+		defer resp.Body.Close()
+
+		var body gatewayError
+		require.Nil(t, gojson.NewDecoder(resp.Body).Decode(&body))
+
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+		assert.Equal(t, gatewayError{Status: 404, ID: "missing", Detail: "no such thing"}, body)
+	})
+	t.Run("response headers", func(t *testing.T) {
+		resp, err := http.Post(url+"/echo/Internal", "application/json", nil)
+		require.Nil(t, err)
+		resp.Body.Close()
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.NotEmpty(t, resp.Header.Get("X-Mice-Reqid"))
+		assert.Empty(t, resp.Header.Get("X-Mice-Parentreq"), "internal headers aren't exposed")
+		assert.Empty(t, resp.Header.Get("X-Mice-Userid"))
+		assert.Empty(t, resp.Header.Get("X-Mice-Credit"))
+		assert.Empty(t, resp.Header.Get("X-Mice-Tracing-Traceparent"))
+		assert.Equal(t, "line  X-Injected: 1", resp.Header.Get("X-Mice-Note"))
+		assert.Empty(t, resp.Header.Get("X-Injected"))
+	})
+	t.Run("invalid path", func(t *testing.T) {
+		resp, err := http.Post(url+"/echo", "application/json", nil)
+		require.Nil(t, err)
+		resp.Body.Close()
+
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+}
+
+type userResponse struct {
+	User     uint32
+	Authed   bool
+	Deadline bool
+}
+
+type userHandler struct{}
+
+func (userHandler) User(ctx context.Context, req *struct{}, resp *userResponse) error {
+	resp.User, resp.Authed = auth.GetUserID(ctx)
+	_, resp.Deadline = ctx.Deadline()
+	return nil
+}
+
+// listenRouter starts a gateway listener that passes requests on to a router with a userHandler, like the server does,
+// returning the URLs of the gateway and of the service endpoints
+func listenRouter(t *testing.T) (gateway, service string) {
+	o := &options.Options{
+		Logger: stdout.NewStdoutLogger(" "),
+		Tracer: tracing.NoopTracer(),
+	}
+	msgpack.Codec()(o)
+	json.Codec()(o)
+
+	r := router.NewRouter(o)
+	r.AddHandler(userHandler{}, "user", []string{"User"})
+
+	l, err := newTransport(Gateway("127.0.0.1:0")).Listen(context.Background(), "127.0.0.1:0")
+	require.Nil(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	go l.Accept(context.Background(), func(soc transport.Socket) {
+		go func() {
+			defer soc.Close()
+
+			var req transport.Message
+			if err := soc.Receive(context.Background(), &req); err != nil {
+				return
+			}
+
+			resp := transport.NewMessage()
+			resp.SetRequestID(req.MustGetRequestID())
+
+			path, _ := req.GetPath()
+			if err := r.Handle(context.Background(), path, &req, resp); err != nil {
+				resp.SetError(err)
+			}
+
+			soc.Send(context.Background(), resp)
+		}()
+	})
+
+	hl := l.(*httpListener)
+	return "http://" + hl.gatewayLn.Addr().String(), "http://" + hl.ln.Addr().String()
+}
+
+func TestGatewayIgnoredHeaders(t *testing.T) {
+	url, _ := listenRouter(t)
+
+	req, _ := http.NewRequest(http.MethodPost, url+"/user/User", strings.NewReader("{}"))
+	req.Header.Set("X-Mice-Userid", "42")
+	req.Header.Set("X-Mice-Timeout", "1000")
+
+	resp, err := http.DefaultClient.Do(req)
+	require.Nil(t, err)
+	defer resp.Body.Close()
+
+	var body userResponse
+	require.Nil(t, gojson.NewDecoder(resp.Body).Decode(&body))
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, userResponse{}, body, "the user ID and timeout headers are ignored")
+}
+
+func TestGatewayServiceEndpoints(t *testing.T) {
+	gateway, service := listenRouter(t)
+
+	t.Run("not on the gateway", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodPost, gateway+pathRPC, strings.NewReader("{}"))
+		req.Header.Set("Origin", "https://evil.example.com")
+		req.Header.Set("X-Mice-Path", "user.User")
+		req.Header.Set("X-Mice-Userid", "42")
+
+		resp, err := http.DefaultClient.Do(req)
+		require.Nil(t, err)
+		resp.Body.Close()
+
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+	t.Run("no CORS", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodOptions, service+pathRPC, nil)
+		req.Header.Set("Origin", "https://evil.example.com")
+		req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		req.Header.Set("Access-Control-Request-Headers", "X-Mice-Userid")
+
+		resp, err := http.DefaultClient.Do(req)
+		require.Nil(t, err)
+		resp.Body.Close()
+
+		assert.Empty(t, resp.Header.Get("Access-Control-Allow-Origin"), "browsers can't send the trusted headers")
+		assert.Empty(t, resp.Header.Get("Access-Control-Allow-Headers"))
+	})
+	t.Run("services", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodPost, service+pathRPC, strings.NewReader("{}"))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Mice-Path", "user.User")
+		req.Header.Set("X-Mice-Userid", "42")
+
+		resp, err := http.DefaultClient.Do(req)
+		require.Nil(t, err)
+		defer resp.Body.Close()
+
+		var body userResponse
+		require.Nil(t, gojson.NewDecoder(resp.Body).Decode(&body))
+
+		assert.Equal(t, userResponse{User: 42, Authed: true}, body, "other services are still trusted")
+	})
+}
+
+func TestGatewayContentType(t *testing.T) {
+	url, _ := listenRouter(t)
+
+	tests := []struct {
+		name        string
+		headers     map[string]string
+		status      int
+		contentType string
+	}{
+		{"parameters", map[string]string{"Content-Type": "application/json; charset=utf-8"}, http.StatusOK, "application/json"},
+		{"unsupported", map[string]string{"Content-Type": "application/xml"}, http.StatusUnsupportedMediaType, "application/json"},
+		{"accept", map[string]string{"Accept": "application/msgpack;q=0.9, */*"}, http.StatusOK, "application/msgpack"},
+		{"mice headers first", map[string]string{
+			"Content-Type":        "application/xml",
+			"Accept":              "application/msgpack",
+			"X-Mice-Content-Type": "application/json",
+			"X-Mice-Accept":       "application/json",
+		}, http.StatusOK, "application/json"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodPost, url+"/user/User", strings.NewReader("{}"))
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+
+			resp, err := http.DefaultClient.Do(req)
+			require.Nil(t, err)
+			resp.Body.Close()
+
+			assert.Equal(t, tt.status, resp.StatusCode)
+			assert.Equal(t, tt.contentType, resp.Header.Get("Content-Type"))
+		})
+	}
+}
+
+func TestGatewayRouterErrors(t *testing.T) {
+	url, _ := listenRouter(t)
+
+	tests := []struct {
+		name   string
+		path   string
+		body   string
+		status int
+	}{
+		{"missing endpoint", "/user/Nope", "{}", http.StatusNotFound},
+		{"missing handler", "/nope/User", "{}", http.StatusNotFound},
+		{"malformed body", "/user/User", "{", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.Post(url+tt.path, "application/json", strings.NewReader(tt.body))
+			require.Nil(t, err)
+			defer resp.Body.Close()
+
+			var body gatewayError
+			require.Nil(t, gojson.NewDecoder(resp.Body).Decode(&body))
+
+			assert.Equal(t, tt.status, resp.StatusCode)
+			assert.EqualValues(t, tt.status, body.Status)
+		})
+	}
+}
@@ -2,12 +2,19 @@ package http
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	goerrors "errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/MouseHatGames/mice/errors"
+	"github.com/MouseHatGames/mice/health"
 	"github.com/MouseHatGames/mice/logger"
 	"github.com/MouseHatGames/mice/options"
 	"github.com/MouseHatGames/mice/transport"
@@ -15,69 +22,337 @@ import (
 
 const headerPrefix = "X-Mice-"
 
+const (
+	// pathRPC is where messages are sent with their data as the body and their headers as HTTP headers
+	pathRPC = "/rpc"
+
+	// pathEnvelope is where messages are sent as JSON envelopes, see the Envelope option
+	pathEnvelope = "/request"
+)
+
 type httpTransport struct {
-	log logger.Logger
+	log      logger.Logger
+	health   health.Health
+	client   *http.Client
+	scheme   string
+	cors     *CORSPolicy
+	gateway  string
+	envelope bool
+
+	// legacy holds the addresses that only accept envelopes
+	legacy *legacyHosts
+
+	serverTLS *tls.Config
 }
 
-func Transport() options.Option {
+// Transport sets an HTTP transport as the service's transport. Since every request is a separate HTTP exchange,
+// streaming endpoints can't be called through it and opening a stream fails with ErrStreamsNotSupported.
+func Transport(opts ...Option) options.Option {
 	return func(o *options.Options) {
-		o.Transport = &httpTransport{
-			log: o.Logger.GetLogger("http"),
+		topts := defaultOptions()
+		for _, opt := range opts {
+			opt(&topts)
+		}
+
+		t := &httpTransport{
+			log:      o.Logger.GetLogger("http"),
+			health:   o.Health,
+			scheme:   "http",
+			cors:     topts.CORS,
+			gateway:  topts.Gateway,
+			envelope: topts.Envelope,
+			legacy:   newLegacyHosts(),
+		}
+
+		if topts.CORS != nil {
+			if err := topts.CORS.validate(); err != nil {
+				panic(fmt.Sprintf("invalid CORS policy: %s", err))
+			}
 		}
+
+		var clientTLS *tls.Config
+
+		if topts.TLS != nil {
+			var err error
+
+			// The server and the client must agree on the scheme, otherwise services with the same configuration
+			// wouldn't be able to call each other
+			if topts.TLS.CertFile == "" {
+				panic(fmt.Sprintf("invalid TLS configuration: %s", ErrNoServerCertificate))
+			}
+
+			if clientTLS, err = topts.TLS.clientConfig(t.log); err != nil {
+				panic(fmt.Sprintf("invalid client TLS configuration: %s", err))
+			}
+			if t.serverTLS, err = topts.TLS.serverConfig(t.log); err != nil {
+				panic(fmt.Sprintf("invalid server TLS configuration: %s", err))
+			}
+
+			t.scheme = "https"
+		}
+
+		t.client = topts.newClient(clientTLS)
+		o.Transport = t
 	}
 }
 
 func (t *httpTransport) Listen(ctx context.Context, addr string) (transport.Listener, error) {
+	ln, err := t.listen(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &httpListener{
+		ln:     ln,
+		srv:    &http.Server{},
+		log:    t.log,
+		health: t.health,
+		cors:   t.cors,
+	}
+
+	if t.gateway != "" {
+		if l.gatewayLn, err = t.listen(t.gateway); err != nil {
+			ln.Close()
+			return nil, fmt.Errorf("gateway: %w", err)
+		}
+		l.gatewaySrv = &http.Server{}
+	}
+
+	return l, nil
+}
+
+// listen opens a TCP listener on addr, using TLS if it's enabled
+func (t *httpTransport) listen(addr string) (net.Listener, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("listen: %w", err)
+	}
+
+	if t.serverTLS != nil {
+		ln = tls.NewListener(ln, t.serverTLS)
+	}
+
 	t.log.Infof("listening on %s", addr)
 
-	return &httpListener{addr, t.log}, nil
+	return ln, nil
 }
 
 func (t *httpTransport) Dial(ctx context.Context, addr string) (transport.Socket, error) {
 	t.log.Infof("dialing %s", addr)
 
 	return &httpOutgoingSocket{
-		address: addr,
-		resp:    make(chan *http.Response, 1),
+		address:  addr,
+		scheme:   t.scheme,
+		client:   t.client,
+		envelope: t.envelope,
+		legacy:   t.legacy,
+		resp:     make(chan *http.Response, 1),
+		log:      t.log,
 	}, nil
 }
 
 type httpListener struct {
-	addr string
-	log  logger.Logger
+	ln     net.Listener
+	srv    *http.Server
+	log    logger.Logger
+	health health.Health
+	cors   *CORSPolicy
+
+	// gatewayLn and gatewaySrv serve the gateway, they're nil if it's disabled
+	gatewayLn  net.Listener
+	gatewaySrv *http.Server
 }
 
+// Close stops accepting new connections and requests, closing idle connections and waiting for the requests that
+// are already being received or handled to finish
 func (l *httpListener) Close() error {
-	return nil
+	err := closeServer(l.srv, l.ln)
+
+	if l.gatewaySrv != nil {
+		if gerr := closeServer(l.gatewaySrv, l.gatewayLn); err == nil {
+			err = gerr
+		}
+	}
+
+	return err
+}
+
+// closeServer shuts down srv and closes the listener it serves
+func closeServer(srv *http.Server, ln net.Listener) error {
+	err := srv.Shutdown(context.Background())
+
+	// The server only closes the listener if it's serving it
+	if cerr := ln.Close(); cerr != nil && !goerrors.Is(cerr, net.ErrClosed) && err == nil {
+		err = cerr
+	}
+
+	return err
 }
 
 func (l *httpListener) Accept(ctx context.Context, fn func(transport.Socket)) error {
+	// Browsers are expected to go through the gateway if it's enabled, the service endpoints trust their headers
+	cors := l.cors
+	if l.gatewaySrv != nil {
+		cors = nil
+	}
+
 	handler := http.NewServeMux()
-	handler.HandleFunc("/request", func(rw http.ResponseWriter, r *http.Request) {
-		rw.Header().Add("Access-Control-Allow-Origin", "*")
-		rw.Header().Add("Access-Control-Allow-Methods", "POST")
-		rw.Header().Add("Access-Control-Allow-Headers", "*")
+	handler.HandleFunc(pathRPC, func(rw http.ResponseWriter, r *http.Request) {
+		l.serve(rw, r, cors, fn, func(closer chan<- struct{}) transport.Socket {
+			return &httpIncomingSocket{
+				rw:     rw,
+				r:      r,
+				log:    l.log,
+				closer: closer,
+			}
+		})
+	})
 
-		if r.Method != http.MethodPost {
-			return
-		}
+	// Keep accepting messages from services that still use the envelope format
+	handler.HandleFunc(pathEnvelope, func(rw http.ResponseWriter, r *http.Request) {
+		l.serve(rw, r, cors, fn, func(closer chan<- struct{}) transport.Socket {
+			return &httpIncomingSocket{
+				rw:       rw,
+				r:        r,
+				log:      l.log,
+				closer:   closer,
+				envelope: true,
+			}
+		})
+	})
+
+	if l.health != nil {
+		hh := l.health.Handler()
+		handler.Handle(health.PathHealth, hh)
+		handler.Handle(health.PathReadiness, hh)
+		handler.Handle(health.PathLiveness, hh)
+	}
+
+	l.srv.Handler = handler
 
-		l.log.Debugf("got request from %s", r.RemoteAddr)
+	errs := make(chan error, 2)
+	servers := 1
 
-		close := make(chan struct{}, 1)
+	go func() {
+		errs <- serveHTTP(l.srv, l.ln)
+	}()
 
-		fn(&httpIncomingSocket{
-			rw:     rw,
-			r:      r,
-			log:    l.log,
-			closer: close,
+	if l.gatewaySrv != nil {
+		l.gatewaySrv.Handler = http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			l.serve(rw, r, l.cors, fn, func(closer chan<- struct{}) transport.Socket {
+				return &httpGatewaySocket{
+					rw:     rw,
+					r:      r,
+					log:    l.log,
+					closer: closer,
+				}
+			})
 		})
 
-		<-close
-	})
+		servers++
+		go func() {
+			errs <- serveHTTP(l.gatewaySrv, l.gatewayLn)
+		}()
+	}
 
 	l.log.Debugf("accepting connections")
-	return http.ListenAndServe(l.addr, handler)
+
+	// If one of the servers fails the other one is stopped too, so that the service doesn't run half available
+	var err error
+	for i := 0; i < servers; i++ {
+		if serr := <-errs; serr != nil && err == nil {
+			err = serr
+			l.Close()
+		}
+	}
+
+	return err
+}
+
+// serveHTTP serves srv on ln until the server is closed
+func serveHTTP(srv *http.Server, ln net.Listener) error {
+	if err := srv.Serve(ln); err != nil && !goerrors.Is(err, http.ErrServerClosed) && !goerrors.Is(err, net.ErrClosed) {
+		return err
+	}
+
+	return nil
+}
+
+// serve applies a CORS policy to a request and, if it's a POST request, passes a socket created by newSocket to fn,
+// waiting until the socket is closed
+func (l *httpListener) serve(rw http.ResponseWriter, r *http.Request, cors *CORSPolicy, fn func(transport.Socket), newSocket func(closer chan<- struct{}) transport.Socket) {
+	if cors != nil {
+		cors.apply(rw, r)
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+	case http.MethodOptions:
+		rw.WriteHeader(http.StatusNoContent)
+		return
+	default:
+		rw.Header().Set("Allow", allowedMethods)
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	l.log.Debugf("got request from %s", r.RemoteAddr)
+
+	close := make(chan struct{}, 1)
+
+	fn(newSocket(close))
+
+	<-close
+}
+
+// errorStatus returns the HTTP status that corresponds to an error returned by a handler
+func errorStatus(err error) int {
+	if merr, ok := err.(*errors.Error); ok && merr.StatusCode >= 400 && merr.StatusCode <= 599 {
+		return int(merr.StatusCode)
+	}
+
+	return http.StatusInternalServerError
+}
+
+// writeErrorHeader writes the status and the retry hint of a response that contains an error
+func writeErrorHeader(rw http.ResponseWriter, err error) {
+	if merr, ok := err.(*errors.Error); ok && merr.RetryAfter > 0 {
+		secs := (merr.RetryAfter + time.Second - 1) / time.Second
+		rw.Header().Set("Retry-After", strconv.FormatInt(int64(secs), 10))
+	}
+
+	rw.WriteHeader(errorStatus(err))
+}
+
+// miceHeader returns the HTTP header that a message header is sent as
+func miceHeader(name string) string {
+	return http.CanonicalHeaderKey(headerPrefix + name)
+}
+
+// setMiceHeaders sets the headers of a message as X-Mice-* HTTP headers
+func setMiceHeaders(h http.Header, mh transport.MessageHeaders) {
+	for k, v := range mh {
+		h.Set(headerPrefix+k, headerValue(v))
+	}
+}
+
+// headerValue replaces the characters that aren't allowed in HTTP header values, like the line breaks that some
+// error messages contain
+func headerValue(v string) string {
+	return strings.Map(func(r rune) rune {
+		if (r < ' ' && r != '\t') || r == 0x7f {
+			return ' '
+		}
+		return r
+	}, v)
+}
+
+// contentType returns the HTTP content type of a message's data
+func contentType(msg *transport.Message) string {
+	if ct, ok := msg.GetContentType(); ok {
+		return ct
+	}
+	return "application/octet-stream"
 }
 
 func getMiceHeaders(h http.Header) (mh map[string]string) {
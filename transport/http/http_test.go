@@ -0,0 +1,427 @@
+package http
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/MouseHatGames/mice/errors"
+	"github.com/MouseHatGames/mice/logger/stdout"
+	"github.com/MouseHatGames/mice/options"
+	"github.com/MouseHatGames/mice/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// listenEcho starts a listener that sends back every request it receives, returning its address
+func listenEcho(t *testing.T, tr transport.Transport) string {
+	l, err := tr.Listen(context.Background(), "127.0.0.1:0")
+	require.Nil(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	go l.Accept(context.Background(), func(soc transport.Socket) {
+		go func() {
+			defer soc.Close()
+
+			var msg transport.Message
+			if err := soc.Receive(context.Background(), &msg); err != nil {
+				return
+			}
+			soc.Send(context.Background(), &msg)
+		}()
+	})
+
+	return l.(*httpListener).ln.Addr().String()
+}
+
+func newTransport(opts ...Option) transport.Transport {
+	o := &options.Options{Logger: stdout.NewStdoutLogger(" ")}
+	Transport(opts...)(o)
+	return o.Transport
+}
+
+func TestRoundTrip(t *testing.T) {
+	tr := newTransport(MaxConnsPerHost(1))
+	addr := listenEcho(t, tr)
+
+	for i := 0; i < 3; i++ {
+		soc, err := tr.Dial(context.Background(), addr)
+		require.Nil(t, err)
+
+		req := transport.NewMessage()
+		req.SetPath("handler.Method")
+		req.Data = []byte("hello")
+
+		require.Nil(t, soc.Send(context.Background(), req))
+
+		var resp transport.Message
+		require.Nil(t, soc.Receive(context.Background(), &resp))
+		require.Nil(t, soc.Close())
+
+		path, _ := resp.GetPath()
+		assert.Equal(t, "handler.Method", path)
+		assert.Equal(t, []byte("hello"), resp.Data)
+	}
+}
+
+func TestEnvelope(t *testing.T) {
+	addr := listenEcho(t, newTransport())
+
+	soc, err := newTransport(Envelope()).Dial(context.Background(), addr)
+	require.Nil(t, err)
+	defer soc.Close()
+
+	req := transport.NewMessage()
+	req.SetPath("handler.Method")
+	req.Data = []byte("hello")
+
+	require.Nil(t, soc.Send(context.Background(), req))
+
+	var resp transport.Message
+	require.Nil(t, soc.Receive(context.Background(), &resp))
+
+	path, _ := resp.GetPath()
+	assert.Equal(t, "handler.Method", path)
+	assert.Equal(t, []byte("hello"), resp.Data)
+}
+
+// listenLegacy starts a server that only has the envelope endpoint, like older versions of the transport, and counts
+// the requests sent to each endpoint
+func listenLegacy(t *testing.T) (addr string, rpcRequests, envelopeRequests *int32) {
+	rpcRequests, envelopeRequests = new(int32), new(int32)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(pathRPC, func(rw http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(rpcRequests, 1)
+		http.NotFound(rw, r)
+	})
+	mux.HandleFunc(pathEnvelope, func(rw http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(envelopeRequests, 1)
+		io.Copy(rw, r.Body)
+	})
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	return strings.TrimPrefix(srv.URL, "http://"), rpcRequests, envelopeRequests
+}
+
+// sendEcho sends a request to addr and checks that it's echoed back
+func sendEcho(t *testing.T, tr transport.Transport, addr string) {
+	soc, err := tr.Dial(context.Background(), addr)
+	require.Nil(t, err)
+
+	req := transport.NewMessage()
+	req.SetPath("handler.Method")
+	req.Data = []byte("hello")
+
+	require.Nil(t, soc.Send(context.Background(), req))
+
+	var resp transport.Message
+	require.Nil(t, soc.Receive(context.Background(), &resp))
+	require.Nil(t, soc.Close())
+
+	path, _ := resp.GetPath()
+	assert.Equal(t, "handler.Method", path)
+	assert.Equal(t, []byte("hello"), resp.Data)
+}
+
+func TestEnvelopeFallback(t *testing.T) {
+	addr, rpcRequests, envelopeRequests := listenLegacy(t)
+	tr := newTransport()
+
+	for i := 0; i < 2; i++ {
+		sendEcho(t, tr, addr)
+	}
+
+	assert.EqualValues(t, 1, atomic.LoadInt32(rpcRequests), "the fallback is remembered")
+	assert.EqualValues(t, 2, atomic.LoadInt32(envelopeRequests))
+}
+
+func TestEnvelopeFallbackExpires(t *testing.T) {
+	addr, rpcRequests, envelopeRequests := listenLegacy(t)
+	tr := newTransport()
+
+	// The fallback expires right away, so /rpc is tried again in case the service has been updated
+	tr.(*httpTransport).legacy.ttl = 0
+
+	for i := 0; i < 2; i++ {
+		sendEcho(t, tr, addr)
+	}
+
+	assert.EqualValues(t, 2, atomic.LoadInt32(rpcRequests), "the fallback isn't remembered forever")
+	assert.EqualValues(t, 2, atomic.LoadInt32(envelopeRequests))
+}
+
+func TestNoFallbackOnServiceError(t *testing.T) {
+	tr := newTransport()
+
+	l, err := tr.Listen(context.Background(), "127.0.0.1:0")
+	require.Nil(t, err)
+	defer l.Close()
+
+	go l.Accept(context.Background(), func(soc transport.Socket) {
+		go func() {
+			defer soc.Close()
+
+			var msg transport.Message
+			soc.Receive(context.Background(), &msg)
+
+			resp := transport.NewMessage()
+			resp.SetError(errors.NotFound("no such thing"))
+			soc.Send(context.Background(), resp)
+		}()
+	})
+
+	addr := l.(*httpListener).ln.Addr().String()
+
+	msg := sendMessage(t, tr, addr)
+	merr, _ := msg.GetError()
+
+	assert.Equal(t, errors.NotFound("no such thing"), merr)
+	assert.False(t, tr.(*httpTransport).legacy.has(addr), "services that return 404 errors still get raw requests")
+}
+
+func TestRawFormat(t *testing.T) {
+	addr := listenEcho(t, newTransport())
+
+	req, err := http.NewRequest(http.MethodPost, "http://"+addr+"/rpc", strings.NewReader(`{"text":"hello"}`))
+	require.Nil(t, err)
+	req.Header.Set("X-Mice-Path", "handler.Method")
+	req.Header.Set("X-Mice-Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	require.Nil(t, err)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	require.Nil(t, err)
+
+	assert.Equal(t, `{"text":"hello"}`, string(body), "the data isn't wrapped in an envelope")
+	assert.Equal(t, "handler.Method", resp.Header.Get("X-Mice-Path"))
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+}
+
+func TestConnectionReuse(t *testing.T) {
+	tr := newTransport()
+	addr := listenEcho(t, tr)
+
+	var reused []bool
+	ctx := httptrace.WithClientTrace(context.Background(), &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			reused = append(reused, info.Reused)
+		},
+	})
+
+	for i := 0; i < 3; i++ {
+		soc, err := tr.Dial(ctx, addr)
+		require.Nil(t, err)
+
+		req := transport.NewMessage()
+		req.Data = []byte("hello")
+		require.Nil(t, soc.Send(ctx, req))
+
+		// The response of the second request is never received, so it's discarded when the socket is closed
+		if i != 1 {
+			var resp transport.Message
+			require.Nil(t, soc.Receive(ctx, &resp))
+		}
+
+		require.Nil(t, soc.Close())
+	}
+
+	assert.Equal(t, []bool{false, true, true}, reused)
+}
+
+func TestCloseEndlessBody(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		chunk := make([]byte, 1024)
+		for r.Context().Err() == nil {
+			if _, err := rw.Write(chunk); err != nil {
+				return
+			}
+		}
+	}))
+	defer srv.Close()
+
+	soc, err := newTransport().Dial(context.Background(), strings.TrimPrefix(srv.URL, "http://"))
+	require.Nil(t, err)
+	require.Nil(t, soc.Send(context.Background(), transport.NewMessage()))
+
+	closed := make(chan struct{})
+	go func() {
+		soc.Close()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("closing the socket reads the whole body")
+	}
+}
+
+func TestCloseWaitsForRequests(t *testing.T) {
+	l, err := newTransport().Listen(context.Background(), "127.0.0.1:0")
+	require.Nil(t, err)
+
+	received := make(chan struct{})
+
+	go l.Accept(context.Background(), func(soc transport.Socket) {
+		close(received)
+
+		go func() {
+			defer soc.Close()
+
+			var msg transport.Message
+			if err := soc.Receive(context.Background(), &msg); err != nil {
+				return
+			}
+			soc.Send(context.Background(), &msg)
+		}()
+	})
+
+	addr := l.(*httpListener).ln.Addr().String()
+
+	body, bodyw := io.Pipe()
+	respc := make(chan *http.Response, 1)
+
+	go func() {
+		resp, err := http.Post("http://"+addr+"/rpc", "application/octet-stream", body)
+		if err == nil {
+			respc <- resp
+		}
+		close(respc)
+	}()
+
+	// The request is being handled but its body hasn't been sent yet
+	bodyw.Write([]byte("hel"))
+	<-received
+
+	closed := make(chan error, 1)
+	go func() {
+		closed <- l.Close()
+	}()
+
+	select {
+	case <-closed:
+		t.Fatal("listener closed before the request was handled")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	bodyw.Write([]byte("lo"))
+	bodyw.Close()
+
+	resp, ok := <-respc
+	require.True(t, ok, "the request succeeds")
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	require.Nil(t, err)
+	assert.Equal(t, "hello", string(data))
+
+	assert.Nil(t, <-closed)
+}
+
+func TestStreamsNotSupported(t *testing.T) {
+	tr := newTransport()
+	addr := listenEcho(t, tr)
+
+	soc, err := tr.Dial(context.Background(), addr)
+	require.Nil(t, err)
+	defer soc.Close()
+
+	req := transport.NewMessage()
+	req.SetRandomRequestID()
+	req.SetStream(transport.StreamOpen)
+
+	assert.ErrorIs(t, soc.Send(context.Background(), req), ErrStreamsNotSupported)
+
+	req = transport.NewMessage()
+	require.Nil(t, soc.Send(context.Background(), req))
+	assert.ErrorIs(t, soc.Send(context.Background(), req), ErrResponseNotReceived, "sending doesn't block")
+}
+
+func TestHeaderValue(t *testing.T) {
+	assert.Equal(t, "first line second\tline", headerValue("first line\nsecond\tline"))
+}
+
+func TestErrorStatus(t *testing.T) {
+	tr := newTransport()
+
+	l, err := tr.Listen(context.Background(), "127.0.0.1:0")
+	require.Nil(t, err)
+	defer l.Close()
+
+	go l.Accept(context.Background(), func(soc transport.Socket) {
+		go func() {
+			defer soc.Close()
+
+			var msg transport.Message
+			soc.Receive(context.Background(), &msg)
+
+			resp := transport.NewMessage()
+			resp.SetError(errors.NotFound("no such thing"))
+			soc.Send(context.Background(), resp)
+		}()
+	})
+
+	addr := l.(*httpListener).ln.Addr().String()
+
+	resp, err := http.Post("http://"+addr+"/request", "application/json", strings.NewReader("{}"))
+	require.Nil(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	msg := sendMessage(t, tr, addr)
+	merr, _ := msg.GetError()
+
+	assert.Equal(t, errors.NotFound("no such thing"), merr)
+}
+
+func TestProxyError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		http.Error(rw, "upstream unavailable", http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	msg := sendMessage(t, newTransport(), strings.TrimPrefix(srv.URL, "http://"))
+	merr, ok := msg.GetError()
+
+	require.True(t, ok)
+	assert.Equal(t, errors.NewError(http.StatusBadGateway, "upstream unavailable"), merr)
+}
+
+func TestRetryAfter(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		writeErrorHeader(rw, errors.ServiceUnavailable(1500*time.Millisecond, "busy"))
+	}))
+	defer srv.Close()
+
+	msg := sendMessage(t, newTransport(), strings.TrimPrefix(srv.URL, "http://"))
+	merr, ok := msg.GetError()
+
+	require.True(t, ok)
+	assert.EqualValues(t, http.StatusServiceUnavailable, merr.(*errors.Error).StatusCode)
+	assert.Equal(t, 2*time.Second, merr.(*errors.Error).RetryAfter, "the hint is rounded up to whole seconds")
+}
+
+func sendMessage(t *testing.T, tr transport.Transport, addr string) *transport.Message {
+	soc, err := tr.Dial(context.Background(), addr)
+	require.Nil(t, err)
+	defer soc.Close()
+
+	require.Nil(t, soc.Send(context.Background(), transport.NewMessage()))
+
+	var msg transport.Message
+	require.Nil(t, soc.Receive(context.Background(), &msg))
+
+	return &msg
+}
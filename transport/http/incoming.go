@@ -18,6 +18,9 @@ type httpIncomingSocket struct {
 	closer          chan<- struct{}
 	sentResponse    bool
 	receivedRequest bool
+
+	// envelope is true if the request was sent as a JSON envelope, in which case the response is sent in the same format
+	envelope bool
 }
 
 var _ transport.Socket = (*httpIncomingSocket)(nil)
@@ -36,8 +39,30 @@ func (s *httpIncomingSocket) Send(ctx context.Context, msg *transport.Message) e
 
 	s.log.Debugf("sending response with %d bytes", len(msg.Data))
 
-	if err := marshalMessage(s.rw, msg); err != nil {
-		return fmt.Errorf("encode message: %w", err)
+	if s.envelope {
+		// The error is still sent in the message, the status is only set so that proxies and load balancers can see it
+		if err, ok := msg.GetError(); ok {
+			s.rw.Header().Set("Content-Type", "application/json")
+			writeErrorHeader(s.rw, err)
+		}
+
+		if err := marshalMessage(s.rw, msg); err != nil {
+			return fmt.Errorf("encode message: %w", err)
+		}
+
+		return nil
+	}
+
+	h := s.rw.Header()
+	setMiceHeaders(h, msg.MessageHeaders)
+	h.Set("Content-Type", contentType(msg))
+
+	if err, ok := msg.GetError(); ok {
+		writeErrorHeader(s.rw, err)
+	}
+
+	if _, err := s.rw.Write(msg.Data); err != nil {
+		return fmt.Errorf("write body: %w", err)
 	}
 
 	return nil
@@ -49,8 +74,18 @@ func (s *httpIncomingSocket) Receive(ctx context.Context, msg *transport.Message
 	}
 	s.receivedRequest = true
 
-	if err := unmarshalMessage(s.r.Body, msg); err != nil {
-		return fmt.Errorf("read message: %w", err)
+	if s.envelope {
+		if err := unmarshalMessage(s.r.Body, msg); err != nil {
+			return fmt.Errorf("read message: %w", err)
+		}
+	} else {
+		data, err := io.ReadAll(s.r.Body)
+		if err != nil {
+			return fmt.Errorf("read body: %w", err)
+		}
+
+		msg.MessageHeaders = getMiceHeaders(s.r.Header)
+		msg.Data = data
 	}
 
 	s.log.Debugf("received request with %d bytes", len(msg.Data))
@@ -0,0 +1,227 @@
+package http
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Options holds the configuration of the HTTP transport
+type Options struct {
+	// Client is the HTTP client used to send requests. If set, all other client options are ignored
+	Client *http.Client
+
+	// RoundTripper is used by the default client to send requests. If set, the connection options are ignored
+	RoundTripper http.RoundTripper
+
+	DialTimeout           time.Duration
+	KeepAlive             time.Duration
+	ResponseHeaderTimeout time.Duration
+	IdleConnTimeout       time.Duration
+	MaxIdleConns          int
+	MaxIdleConnsPerHost   int
+	MaxConnsPerHost       int
+
+	// TLS enables TLS on both the server and the client if it's not nil, in which case it must have a server certificate
+	TLS *TLSOptions
+
+	// CORS is the policy applied to requests made by browsers. No CORS headers are sent if it's nil
+	CORS *CORSPolicy
+
+	// Gateway is the address where every endpoint is exposed at POST /{handler}/{method}, see the Gateway option.
+	// The gateway is disabled if it's empty
+	Gateway string
+
+	// Envelope makes the client send messages in the JSON envelope format, see the Envelope option
+	Envelope bool
+}
+
+// Option represents a function that can be used to mutate an Options object
+type Option func(*Options)
+
+func defaultOptions() Options {
+	return Options{
+		DialTimeout:         5 * time.Second,
+		KeepAlive:           30 * time.Second,
+		IdleConnTimeout:     90 * time.Second,
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: 16,
+		CORS:                DefaultCORSPolicy(),
+	}
+}
+
+// Client sets the HTTP client that will be used to send requests
+func Client(c *http.Client) Option {
+	return func(o *Options) {
+		o.Client = c
+	}
+}
+
+// RoundTripper sets the round tripper, usually an *http.Transport, that the client will use to send requests
+func RoundTripper(rt http.RoundTripper) Option {
+	return func(o *Options) {
+		o.RoundTripper = rt
+	}
+}
+
+// DialTimeout sets the maximum time to wait for a connection to be established. Defaults to 5 seconds
+func DialTimeout(d time.Duration) Option {
+	return func(o *Options) {
+		o.DialTimeout = d
+	}
+}
+
+// KeepAlive sets the interval between TCP keep-alive probes on outgoing connections. Defaults to 30 seconds
+func KeepAlive(d time.Duration) Option {
+	return func(o *Options) {
+		o.KeepAlive = d
+	}
+}
+
+// ResponseHeaderTimeout sets the maximum time to wait for a response's headers after sending a request.
+// There is no timeout by default, calls are expected to be bound by their context instead
+func ResponseHeaderTimeout(d time.Duration) Option {
+	return func(o *Options) {
+		o.ResponseHeaderTimeout = d
+	}
+}
+
+// IdleConnTimeout sets the time after which an idle connection is closed. Defaults to 90 seconds
+func IdleConnTimeout(d time.Duration) Option {
+	return func(o *Options) {
+		o.IdleConnTimeout = d
+	}
+}
+
+// MaxIdleConns sets the maximum number of idle connections kept across all services. Defaults to 100
+func MaxIdleConns(n int) Option {
+	return func(o *Options) {
+		o.MaxIdleConns = n
+	}
+}
+
+// MaxIdleConnsPerHost sets the maximum number of idle connections kept to every service address. Defaults to 16
+func MaxIdleConnsPerHost(n int) Option {
+	return func(o *Options) {
+		o.MaxIdleConnsPerHost = n
+	}
+}
+
+// MaxConnsPerHost limits the number of connections to every service address. There is no limit by default
+func MaxConnsPerHost(n int) Option {
+	return func(o *Options) {
+		o.MaxConnsPerHost = n
+	}
+}
+
+// TLS enables TLS using the server certificate in certFile and keyFile, and makes calls to other services over HTTPS.
+// It's required by all other TLS options, so that services with the same configuration can call each other.
+func TLS(certFile, keyFile string) Option {
+	return func(o *Options) {
+		o.tls().CertFile = certFile
+		o.tls().KeyFile = keyFile
+	}
+}
+
+// ClientCertificate sets the certificate that is presented when calling other services.
+// If not set, the server certificate is presented when using mutual TLS.
+func ClientCertificate(certFile, keyFile string) Option {
+	return func(o *Options) {
+		o.tls().ClientCertFile = certFile
+		o.tls().ClientKeyFile = keyFile
+	}
+}
+
+// CA adds PEM files containing certificate authorities that are trusted to sign other services' certificates,
+// instead of the system's pool
+func CA(files ...string) Option {
+	return func(o *Options) {
+		o.tls().CAFiles = append(o.tls().CAFiles, files...)
+	}
+}
+
+// MutualTLS makes the server require and verify client certificates, and the client present one
+func MutualTLS() Option {
+	return func(o *Options) {
+		o.tls().MutualTLS = true
+	}
+}
+
+// CertReloadInterval sets how often certificate files are checked for changes. Defaults to DefaultCertReloadInterval
+func CertReloadInterval(d time.Duration) Option {
+	return func(o *Options) {
+		o.tls().ReloadInterval = d
+	}
+}
+
+func (o *Options) tls() *TLSOptions {
+	if o.TLS == nil {
+		o.TLS = &TLSOptions{
+			ReloadInterval: DefaultCertReloadInterval,
+		}
+	}
+	return o.TLS
+}
+
+// CORS sets the policy that is applied to requests made by browsers, replacing DefaultCORSPolicy. A nil policy disables CORS.
+// Policies that allow credentials from any origin are rejected with ErrWildcardCredentials
+func CORS(p *CORSPolicy) Option {
+	return func(o *Options) {
+		o.CORS = p
+	}
+}
+
+// Envelope makes the client send messages as JSON objects that contain both the headers and the base64-encoded data
+// to the /request endpoint, which is the format used by older versions of this transport. By default the data is sent
+// as the raw body to the /rpc endpoint, with the headers as X-Mice-* HTTP headers. Servers accept both formats, and
+// services that haven't been updated yet are detected by their 404 response to /rpc, after which requests to them are
+// sent as envelopes for 10 minutes before trying /rpc again. This option skips those requests to /rpc.
+func Envelope() Option {
+	return func(o *Options) {
+		o.Envelope = true
+	}
+}
+
+// Gateway exposes every endpoint at POST /{handler}/{method} on a separate listener at addr, so that they can be
+// called by browsers and third parties. The request and response bodies contain the data encoded with the service's
+// codec, message headers are sent as X-Mice-* HTTP headers and errors are returned with their status code as the
+// HTTP status.
+//
+// The endpoints used by other services trust headers like the user ID, so only the gateway's address should be
+// reachable from outside. The CORS policy is only applied to the gateway while it's enabled.
+func Gateway(addr string) Option {
+	return func(o *Options) {
+		o.Gateway = addr
+	}
+}
+
+// newClient creates the HTTP client described by the options. Connections are pooled by the underlying transport
+// and kept alive per target address.
+func (o *Options) newClient(tlsConfig *tls.Config) *http.Client {
+	if o.Client != nil {
+		return o.Client
+	}
+
+	rt := o.RoundTripper
+	if rt == nil {
+		dialer := &net.Dialer{
+			Timeout:   o.DialTimeout,
+			KeepAlive: o.KeepAlive,
+		}
+
+		rt = &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			DialContext:           dialer.DialContext,
+			ResponseHeaderTimeout: o.ResponseHeaderTimeout,
+			IdleConnTimeout:       o.IdleConnTimeout,
+			MaxIdleConns:          o.MaxIdleConns,
+			MaxIdleConnsPerHost:   o.MaxIdleConnsPerHost,
+			MaxConnsPerHost:       o.MaxConnsPerHost,
+			TLSClientConfig:       tlsConfig,
+			ForceAttemptHTTP2:     tlsConfig != nil,
+		}
+	}
+
+	return &http.Client{Transport: rt}
+}
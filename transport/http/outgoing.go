@@ -4,61 +4,289 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	goerrors "errors"
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/MouseHatGames/mice/errors"
 	"github.com/MouseHatGames/mice/logger"
 	"github.com/MouseHatGames/mice/transport"
 )
 
+// ErrStreamsNotSupported is returned when trying to open a stream through the HTTP transport
+var ErrStreamsNotSupported = goerrors.New("streams aren't supported by the HTTP transport")
+
+// ErrResponseNotReceived is returned when sending a request through a socket whose previous response hasn't been received
+var ErrResponseNotReceived = goerrors.New("previous response hasn't been received")
+
 type httpOutgoingSocket struct {
 	address string
+	scheme  string
+	client  *http.Client
 	resp    chan *http.Response
 	log     logger.Logger
+
+	// envelope makes messages be sent as JSON envelopes, see the Envelope option
+	envelope bool
+
+	// legacy holds the addresses that only accept envelopes, which is shared by all sockets of the transport
+	legacy *legacyHosts
+
+	// sentEnvelope is true if the request whose response is waiting in resp was sent as an envelope
+	sentEnvelope bool
 }
 
 var _ transport.Socket = (*httpOutgoingSocket)(nil)
 
 func (s *httpOutgoingSocket) Close() error {
 	s.log.Debugf("closing outgoing socket")
-	return nil
+
+	// Release any response that wasn't received
+	for {
+		select {
+		case resp := <-s.resp:
+			closeBody(resp.Body)
+		default:
+			return nil
+		}
+	}
+}
+
+// maxDrainLength is the maximum number of bytes that are read from the rest of a response body before closing it.
+// Connections with more data left aren't worth waiting for, so they're closed instead of reused.
+const maxDrainLength = 64 << 10
+
+// closeBody reads the remaining data in a response body and closes it, so that its connection can be reused
+func closeBody(body io.ReadCloser) {
+	io.CopyN(io.Discard, body, maxDrainLength)
+	body.Close()
 }
 
 func (s *httpOutgoingSocket) Send(ctx context.Context, msg *transport.Message) error {
+	if _, ok := msg.GetStream(); ok {
+		return ErrStreamsNotSupported
+	}
+
 	s.log.Debugf("sending request with %d bytes", len(msg.Data))
 
-	b, err := json.Marshal(msg)
+	envelope := s.envelope || s.legacy.has(s.address)
+
+	resp, err := s.do(ctx, msg, envelope)
+	if err != nil {
+		return err
+	}
+
+	// Services that only have the envelope endpoint don't know about /rpc, so the request is sent again as an envelope.
+	// Errors sent by services always have mice headers, unlike the ones sent by servers that don't know the path.
+	if !envelope && resp.StatusCode == http.StatusNotFound && !hasMiceHeaders(resp.Header) {
+		closeBody(resp.Body)
+
+		s.log.Infof("%s doesn't support %s, falling back to %s", s.address, pathRPC, pathEnvelope)
+		s.legacy.add(s.address)
+
+		envelope = true
+		if resp, err = s.do(ctx, msg, envelope); err != nil {
+			return err
+		}
+	}
+
+	// Every request must be followed by a Receive, so there's never more than one response waiting
+	select {
+	case s.resp <- resp:
+		s.sentEnvelope = envelope
+	default:
+		closeBody(resp.Body)
+		return ErrResponseNotReceived
+	}
+
+	return nil
+}
+
+// do sends a message in a request, either as an envelope or with the raw format
+func (s *httpOutgoingSocket) do(ctx context.Context, msg *transport.Message, envelope bool) (*http.Response, error) {
+	req, err := s.newRequest(ctx, msg, envelope)
 	if err != nil {
-		return fmt.Errorf("encode message: %w", err)
+		return nil, fmt.Errorf("create request: %w", err)
 	}
-	br := bytes.NewReader(b)
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("http://%s/request", s.address), br)
+	resp, err := s.client.Do(req)
 	if err != nil {
-		return fmt.Errorf("create request: %w", err)
+		return nil, fmt.Errorf("request: %w", err)
+	}
+
+	return resp, nil
+}
+
+func (s *httpOutgoingSocket) newRequest(ctx context.Context, msg *transport.Message, envelope bool) (*http.Request, error) {
+	if envelope {
+		b, err := json.Marshal(msg)
+		if err != nil {
+			return nil, fmt.Errorf("encode message: %w", err)
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url(pathEnvelope), bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("Content-Type", "application/json")
+		return req, nil
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url(pathRPC), bytes.NewReader(msg.Data))
 	if err != nil {
-		return fmt.Errorf("request: %w", err)
+		return nil, err
 	}
-	s.resp <- resp
 
-	return nil
+	setMiceHeaders(req.Header, msg.MessageHeaders)
+	req.Header.Set("Content-Type", contentType(msg))
+
+	return req, nil
+}
+
+func (s *httpOutgoingSocket) url(path string) string {
+	return fmt.Sprintf("%s://%s%s", s.scheme, s.address, path)
 }
 
 func (s *httpOutgoingSocket) Receive(ctx context.Context, msg *transport.Message) error {
-	resp, ok := <-s.resp
-	if !ok {
+	var resp *http.Response
+
+	select {
+	case resp = <-s.resp:
+	default:
 		return io.EOF
 	}
+	defer closeBody(resp.Body)
+
+	if s.sentEnvelope {
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return s.receiveErrorResponse(resp, msg)
+		}
+
+		if err := unmarshalMessage(resp.Body, msg); err != nil {
+			return fmt.Errorf("read message: %w", err)
+		}
+	} else {
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return fmt.Errorf("read body: %w", err)
+		}
 
-	if err := unmarshalMessage(resp.Body, msg); err != nil {
-		return fmt.Errorf("read message: %w", err)
+		msg.MessageHeaders = getMiceHeaders(resp.Header)
+		msg.Data = data
+
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			if _, ok := msg.GetError(); !ok {
+				s.setProxyError(resp, data, msg)
+			}
+		}
 	}
 
 	s.log.Debugf("received response with %d bytes", len(msg.Data))
 
 	return nil
 }
+
+// maxErrorBodyLength is the maximum length of the body of a non-mice error response that is included in the error
+const maxErrorBodyLength = 256
+
+// receiveErrorResponse reads an envelope response with a non-2xx status into msg. If it doesn't contain an error sent
+// by a service, for example because it was returned by a proxy, an error with the response's status code is set instead.
+func (s *httpOutgoingSocket) receiveErrorResponse(resp *http.Response, msg *transport.Message) error {
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("read body: %w", err)
+	}
+
+	if err := unmarshalMessage(bytes.NewReader(b), msg); err == nil {
+		if _, ok := msg.GetError(); ok {
+			return nil
+		}
+	}
+
+	s.setProxyError(resp, b, msg)
+	return nil
+}
+
+// setProxyError sets an error with the status code of a response on msg, for responses with a non-2xx status that
+// weren't sent by a service
+func (s *httpOutgoingSocket) setProxyError(resp *http.Response, body []byte, msg *transport.Message) {
+	s.log.Debugf("received HTTP %d response that wasn't sent by a service", resp.StatusCode)
+
+	detail := strings.TrimSpace(string(body))
+	if len(detail) > maxErrorBodyLength {
+		detail = detail[:maxErrorBodyLength]
+	}
+	if detail == "" {
+		detail = fmt.Sprintf("unexpected response from %s", s.address)
+	}
+
+	merr := errors.NewError(int16(resp.StatusCode), "%s", detail)
+
+	// Proxies may also ask us to back off, but only in seconds since the HTTP date format isn't worth supporting here
+	if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs > 0 {
+		merr.RetryAfter = time.Duration(secs) * time.Second
+	}
+
+	*msg = transport.Message{}
+	msg.SetError(merr)
+}
+
+// hasMiceHeaders returns true if h has any header that was set by a service
+func hasMiceHeaders(h http.Header) bool {
+	for k := range h {
+		if strings.HasPrefix(k, headerPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// legacyHostTTL is how long an address is remembered to only accept envelopes, after which /rpc is tried again in
+// case the service has been updated
+const legacyHostTTL = 10 * time.Minute
+
+// legacyHosts holds the addresses of services that only accept messages in the envelope format, so that requests to
+// them aren't sent to /rpc first every time
+type legacyHosts struct {
+	// addrs holds the time when each address expires
+	addrs map[string]time.Time
+	ttl   time.Duration
+	mu    sync.Mutex
+}
+
+func newLegacyHosts() *legacyHosts {
+	return &legacyHosts{
+		addrs: make(map[string]time.Time),
+		ttl:   legacyHostTTL,
+	}
+}
+
+func (l *legacyHosts) has(addr string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	expires, ok := l.addrs[addr]
+	if !ok {
+		return false
+	}
+
+	if !time.Now().Before(expires) {
+		delete(l.addrs, addr)
+		return false
+	}
+
+	return true
+}
+
+func (l *legacyHosts) add(addr string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.addrs[addr] = time.Now().Add(l.ttl)
+}
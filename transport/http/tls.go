@@ -0,0 +1,206 @@
+package http
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/MouseHatGames/mice/logger"
+)
+
+// DefaultCertReloadInterval is how often certificate files are checked for changes by default
+const DefaultCertReloadInterval = 30 * time.Second
+
+var ErrNoCertificates = errors.New("no certificates found in CA file")
+var ErrNoServerCertificate = errors.New("TLS options require a server certificate, see the TLS option")
+
+// TLSOptions holds the TLS configuration of the HTTP transport
+type TLSOptions struct {
+	// CertFile and KeyFile contain the certificate presented by the server
+	CertFile, KeyFile string
+
+	// ClientCertFile and ClientKeyFile contain the certificate presented when calling other services.
+	// If they are empty and MutualTLS is true, the server certificate is presented instead.
+	ClientCertFile, ClientKeyFile string
+
+	// CAFiles contain the certificate authorities that are used to verify other services' certificates,
+	// both when calling them and when they present a client certificate. The system pool is used if it's empty
+	CAFiles []string
+
+	// MutualTLS makes the server require client certificates signed by one of the CAs
+	MutualTLS bool
+
+	// ReloadInterval is how often certificate files are checked for changes, so that certificates can be
+	// rotated without restarting the service
+	ReloadInterval time.Duration
+}
+
+func (o *TLSOptions) clientCertFiles() (string, string) {
+	if o.ClientCertFile != "" {
+		return o.ClientCertFile, o.ClientKeyFile
+	}
+	if o.MutualTLS {
+		return o.CertFile, o.KeyFile
+	}
+	return "", ""
+}
+
+func (o *TLSOptions) loadCAs() (*x509.CertPool, error) {
+	if len(o.CAFiles) == 0 {
+		return nil, nil
+	}
+
+	pool := x509.NewCertPool()
+
+	for _, f := range o.CAFiles {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("read CA file: %w", err)
+		}
+
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("%s: %w", f, ErrNoCertificates)
+		}
+	}
+
+	return pool, nil
+}
+
+func (o *TLSOptions) serverConfig(log logger.Logger) (*tls.Config, error) {
+	certs := newCertReloader(o.CertFile, o.KeyFile, o.ReloadInterval, log)
+
+	// Load the certificate now so that a bad one is reported when the service is created instead of on the first handshake
+	if _, err := certs.get(); err != nil {
+		return nil, err
+	}
+
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return certs.get()
+		},
+	}
+
+	if o.MutualTLS {
+		pool, err := o.loadCAs()
+		if err != nil {
+			return nil, err
+		}
+
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return cfg, nil
+}
+
+func (o *TLSOptions) clientConfig(log logger.Logger) (*tls.Config, error) {
+	pool, err := o.loadCAs()
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		RootCAs:    pool,
+	}
+
+	if certFile, keyFile := o.clientCertFiles(); certFile != "" {
+		certs := newCertReloader(certFile, keyFile, o.ReloadInterval, log)
+		if _, err := certs.get(); err != nil {
+			return nil, err
+		}
+
+		cfg.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return certs.get()
+		}
+	}
+
+	return cfg, nil
+}
+
+// certReloader loads a certificate, reloading it when its files change
+type certReloader struct {
+	certFile, keyFile string
+	interval          time.Duration
+	log               logger.Logger
+
+	cert      *tls.Certificate
+	modTime   time.Time
+	checkedAt time.Time
+	mu        sync.Mutex
+}
+
+func newCertReloader(certFile, keyFile string, interval time.Duration, log logger.Logger) *certReloader {
+	return &certReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		interval: interval,
+		log:      log,
+	}
+}
+
+func (r *certReloader) get() (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.cert != nil && time.Since(r.checkedAt) < r.interval {
+		return r.cert, nil
+	}
+	r.checkedAt = time.Now()
+
+	modTime, err := latestModTime(r.certFile, r.keyFile)
+	if err != nil {
+		return r.keepOld(fmt.Errorf("stat certificate: %w", err))
+	}
+
+	if r.cert != nil && !modTime.After(r.modTime) {
+		return r.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return r.keepOld(fmt.Errorf("load certificate: %w", err))
+	}
+
+	if r.cert != nil {
+		r.log.Infof("reloaded certificate %s", r.certFile)
+	}
+
+	r.cert = &cert
+	r.modTime = modTime
+
+	return r.cert, nil
+}
+
+// keepOld returns the previously loaded certificate if there is one, so that a certificate that is being
+// rotated doesn't break new connections
+func (r *certReloader) keepOld(err error) (*tls.Certificate, error) {
+	if r.cert == nil {
+		return nil, err
+	}
+
+	r.log.Errorf("keeping previous certificate: %s", err)
+	return r.cert, nil
+}
+
+func latestModTime(files ...string) (time.Time, error) {
+	var latest time.Time
+
+	for _, f := range files {
+		st, err := os.Stat(f)
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		if st.ModTime().After(latest) {
+			latest = st.ModTime()
+		}
+	}
+
+	return latest, nil
+}
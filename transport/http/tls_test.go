@@ -0,0 +1,174 @@
+package http
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/MouseHatGames/mice/logger/stdout"
+	"github.com/MouseHatGames/mice/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// writeCert creates a certificate signed by parent, or self-signed if parent is nil, and writes it to dir
+func writeCert(t *testing.T, dir, name string, parent *testCert) (*testCert, string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	signer := &testCert{tmpl, key}
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+	} else {
+		signer = parent
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer.cert, &key.PublicKey, signer.key)
+	require.Nil(t, err)
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.Nil(t, err)
+
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+
+	require.Nil(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.Nil(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+
+	cert, _ := x509.ParseCertificate(der)
+	return &testCert{cert, key}, certFile, keyFile
+}
+
+func sendHello(tr transport.Transport, addr string) error {
+	soc, err := tr.Dial(context.Background(), addr)
+	if err != nil {
+		return err
+	}
+	defer soc.Close()
+
+	req := transport.NewMessage()
+	req.Data = []byte("hello")
+
+	if err := soc.Send(context.Background(), req); err != nil {
+		return err
+	}
+
+	var resp transport.Message
+	return soc.Receive(context.Background(), &resp)
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+
+	ca, caFile, _ := writeCert(t, dir, "ca", nil)
+	_, serverCert, serverKey := writeCert(t, dir, "server", ca)
+	_, clientCert, clientKey := writeCert(t, dir, "client", ca)
+	_, otherCert, otherKey := writeCert(t, dir, "other", ca)
+
+	server := newTransport(TLS(serverCert, serverKey), CA(caFile), MutualTLS())
+	addr := listenEcho(t, server)
+
+	t.Run("valid client certificate", func(t *testing.T) {
+		tr := newTransport(TLS(otherCert, otherKey), CA(caFile), ClientCertificate(clientCert, clientKey))
+
+		assert.Nil(t, sendHello(tr, addr))
+	})
+	t.Run("server certificate as client certificate", func(t *testing.T) {
+		assert.Nil(t, sendHello(server, addr))
+	})
+	t.Run("no client certificate", func(t *testing.T) {
+		tr := newTransport(TLS(otherCert, otherKey), CA(caFile))
+
+		assert.NotNil(t, sendHello(tr, addr))
+	})
+	t.Run("untrusted server", func(t *testing.T) {
+		tr := newTransport(TLS(otherCert, otherKey), ClientCertificate(clientCert, clientKey))
+
+		assert.NotNil(t, sendHello(tr, addr))
+	})
+}
+
+func TestTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+
+	ca, caFile, _ := writeCert(t, dir, "ca", nil)
+	_, certFile, keyFile := writeCert(t, dir, "server", ca)
+
+	t.Run("same configuration", func(t *testing.T) {
+		tr := newTransport(TLS(certFile, keyFile), CA(caFile))
+		addr := listenEcho(t, tr)
+
+		assert.Nil(t, sendHello(tr, addr))
+	})
+	t.Run("no server certificate", func(t *testing.T) {
+		assert.Panics(t, func() { newTransport(CA(caFile)) })
+		assert.Panics(t, func() { newTransport(ClientCertificate(certFile, keyFile)) })
+	})
+	t.Run("bad server certificate", func(t *testing.T) {
+		assert.Panics(t, func() { newTransport(TLS(certFile, caFile)) }, "the key doesn't match")
+		assert.Panics(t, func() { newTransport(TLS(filepath.Join(dir, "missing.crt"), keyFile)) })
+	})
+	t.Run("bad client certificate", func(t *testing.T) {
+		assert.Panics(t, func() { newTransport(TLS(certFile, keyFile), ClientCertificate(certFile, caFile)) })
+	})
+}
+
+func TestCertReload(t *testing.T) {
+	dir := t.TempDir()
+
+	ca, _, _ := writeCert(t, dir, "ca", nil)
+	first, certFile, keyFile := writeCert(t, dir, "server", ca)
+
+	r := newCertReloader(certFile, keyFile, 0, stdout.NewStdoutLogger(" "))
+
+	cert, err := r.get()
+	require.Nil(t, err)
+	assert.Equal(t, first.cert.Raw, cert.Certificate[0])
+
+	// Make sure the modification time changes
+	time.Sleep(10 * time.Millisecond)
+	second, _, _ := writeCert(t, dir, "server", ca)
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, future, future)
+
+	cert, err = r.get()
+	require.Nil(t, err)
+	assert.Equal(t, second.cert.Raw, cert.Certificate[0])
+
+	// A broken certificate doesn't replace a working one
+	os.WriteFile(certFile, []byte("garbage"), 0600)
+	future = future.Add(time.Minute)
+	os.Chtimes(certFile, future, future)
+
+	cert, err = r.get()
+	require.Nil(t, err)
+	assert.Equal(t, second.cert.Raw, cert.Certificate[0])
+}
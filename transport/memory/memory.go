@@ -0,0 +1,155 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/MouseHatGames/mice/discovery"
+	"github.com/MouseHatGames/mice/logger"
+	"github.com/MouseHatGames/mice/options"
+	"github.com/MouseHatGames/mice/transport"
+)
+
+var ErrConnectionRefused = errors.New("connection refused")
+var ErrAddressInUse = errors.New("address already in use")
+var ErrInjected = errors.New("injected fault")
+
+type memoryTransport struct {
+	log     logger.Logger
+	network *Network
+	opts    *options.Options
+}
+
+// Options holds the configuration of the memory transport
+type Options struct {
+	Network *Network
+}
+
+// Option represents a function that can be used to mutate an Options object
+type Option func(*Options)
+
+// WithNetwork sets the network that the transport will listen and dial on, instead of the process-wide one
+func WithNetwork(n *Network) Option {
+	return func(o *Options) {
+		o.Network = n
+	}
+}
+
+// Transport sets an in-memory transport as the service's transport, which allows services in the same process
+// to call each other without opening any port.
+//
+// Services listen on the address made up of their name and their RPC port, so Discovery must be used along with it.
+func Transport(opts ...Option) options.Option {
+	return func(o *options.Options) {
+		topts := Options{Network: defaultNetwork}
+		for _, opt := range opts {
+			opt(&topts)
+		}
+
+		o.Transport = &memoryTransport{
+			log:     o.Logger.GetLogger("memory"),
+			network: topts.Network,
+			opts:    o,
+		}
+	}
+}
+
+// Discovery sets a discovery that finds services listening on a memory network, which is the process-wide one if n is nil
+func Discovery(n *Network) options.Option {
+	return func(o *options.Options) {
+		if n == nil {
+			n = defaultNetwork
+		}
+		o.Discovery = &memoryDiscovery{n}
+	}
+}
+
+type memoryDiscovery struct {
+	network *Network
+}
+
+func (d *memoryDiscovery) Find(svc string) (string, error) {
+	if !d.network.hasHost(svc) {
+		return "", discovery.ErrServiceNotRegistered
+	}
+	return svc, nil
+}
+
+func (t *memoryTransport) Listen(ctx context.Context, addr string) (transport.Listener, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("parse address: %w", err)
+	}
+
+	// Listening on all interfaces means listening on the service's name
+	if host == "" {
+		addr = net.JoinHostPort(t.opts.Name, port)
+	}
+
+	l := &memoryListener{
+		addr:    addr,
+		network: t.network,
+		accept:  make(chan *memorySocket),
+		closed:  make(chan struct{}),
+	}
+
+	if err := t.network.register(addr, l); err != nil {
+		return nil, fmt.Errorf("listen on %s: %w", addr, err)
+	}
+
+	t.log.Infof("listening on %s", addr)
+
+	return l, nil
+}
+
+func (t *memoryTransport) Dial(ctx context.Context, addr string) (transport.Socket, error) {
+	l, ok := t.network.getListener(addr)
+	if !ok {
+		return nil, fmt.Errorf("dial %s: %w", addr, ErrConnectionRefused)
+	}
+
+	client, server := newSocketPair(t.network, addr)
+	server.listenerClosed = l.closed
+
+	select {
+	case l.accept <- server:
+		return client, nil
+	case <-l.closed:
+		return nil, fmt.Errorf("dial %s: %w", addr, ErrConnectionRefused)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+type memoryListener struct {
+	addr    string
+	network *Network
+	accept  chan *memorySocket
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (l *memoryListener) Close() error {
+	l.closeOnce.Do(func() {
+		l.network.unregister(l.addr)
+		close(l.closed)
+	})
+	return nil
+}
+
+func (l *memoryListener) Accept(ctx context.Context, fn func(transport.Socket)) error {
+	for {
+		select {
+		case s := <-l.accept:
+			fn(s)
+		case <-l.closed:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,325 @@
+package memory
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/MouseHatGames/mice"
+	"github.com/MouseHatGames/mice/client"
+	"github.com/MouseHatGames/mice/codec/json"
+	"github.com/MouseHatGames/mice/codec/msgpack"
+	"github.com/MouseHatGames/mice/errors"
+	"github.com/MouseHatGames/mice/options"
+	"github.com/MouseHatGames/mice/server"
+	"github.com/MouseHatGames/mice/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type echoRequest struct {
+	Text string
+}
+
+type echoResponse struct {
+	Text string
+}
+
+type echoHandler struct {
+	done chan error
+}
+
+func (*echoHandler) Echo(ctx context.Context, req *echoRequest, resp *echoResponse) error {
+	resp.Text = req.Text
+	return nil
+}
+
+// Count sends back the numbers from 1 to the one in the first message
+func (*echoHandler) Count(ctx context.Context, stream server.Stream) error {
+	var n int
+	if err := stream.Receive(&n); err != nil {
+		return err
+	}
+
+	for i := 1; i <= n; i++ {
+		if err := stream.Send(i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sum adds up all received numbers and sends back the result once the client stops sending
+func (*echoHandler) Sum(ctx context.Context, stream server.Stream) error {
+	var sum int
+
+	for {
+		var n int
+		if err := stream.Receive(&n); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		sum += n
+	}
+
+	return stream.Send(sum)
+}
+
+// Chat echoes every received message until the stream is closed
+func (*echoHandler) Chat(ctx context.Context, stream server.Stream) error {
+	for {
+		var req echoRequest
+		if err := stream.Receive(&req); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if req.Text == "" {
+			return errors.BadRequest("empty message")
+		}
+
+		if err := stream.Send(&echoResponse{req.Text}); err != nil {
+			return err
+		}
+	}
+}
+
+// Wait blocks until the stream is cancelled, sending the context's error to done
+func (h *echoHandler) Wait(ctx context.Context, stream server.Stream) error {
+	<-stream.Context().Done()
+	h.done <- stream.Context().Err()
+	return nil
+}
+
+// startService starts a service on the network and returns it once it's listening
+func startService(t *testing.T, n *Network, name string) mice.Service {
+	return startServiceWithHandler(t, n, name, &echoHandler{})
+}
+
+func startServiceWithHandler(t *testing.T, n *Network, name string, h *echoHandler, opts ...options.Option) mice.Service {
+	started := make(chan struct{})
+
+	svc := mice.NewService(append([]options.Option{
+		options.Name(name),
+		json.Codec(),
+		Transport(WithNetwork(n)),
+		Discovery(n),
+		options.ReadinessDrainDelay(0),
+		options.AfterStart(func() error {
+			close(started)
+			return nil
+		}),
+	}, opts...)...)
+	svc.Server().AddHandler(h, "echo", "Echo", "Count", "Sum", "Chat", "Wait")
+
+	go svc.Start()
+	<-started
+
+	t.Cleanup(func() { svc.Stop(context.Background()) })
+	return svc
+}
+
+func TestServices(t *testing.T) {
+	n := NewNetwork()
+
+	startService(t, n, "a")
+	b := startService(t, n, "b")
+
+	var resp echoResponse
+	err := b.Client().Call("a", "echo.Echo", &echoRequest{"hello"}, &resp)
+
+	require.Nil(t, err)
+	assert.Equal(t, "hello", resp.Text)
+
+	err = b.Client().Call("c", "echo.Echo", &echoRequest{"hello"}, &resp)
+	assert.NotNil(t, err)
+}
+
+func TestFaults(t *testing.T) {
+	n := NewNetwork()
+
+	startService(t, n, "a")
+	b := startService(t, n, "b")
+
+	t.Run("errors", func(t *testing.T) {
+		n.SetAddrFaults("a:7070", Faults{ErrorRate: 1})
+		defer n.ClearFaults()
+
+		var resp echoResponse
+		err := b.Client().Call("a", "echo.Echo", &echoRequest{"hello"}, &resp)
+
+		assert.ErrorIs(t, err, ErrInjected)
+	})
+	t.Run("dropped", func(t *testing.T) {
+		n.SetFaults(Faults{DropRate: 1})
+		defer n.ClearFaults()
+
+		var resp echoResponse
+		err := b.Client().Call("a", "echo.Echo", &echoRequest{"hello"}, &resp, client.Timeout(50*time.Millisecond))
+
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+	t.Run("latency", func(t *testing.T) {
+		n.SetFaults(Faults{Latency: 20 * time.Millisecond})
+		defer n.ClearFaults()
+
+		start := time.Now()
+
+		var resp echoResponse
+		err := b.Client().Call("a", "echo.Echo", &echoRequest{"hello"}, &resp)
+
+		assert.Nil(t, err)
+		assert.True(t, time.Since(start) >= 40*time.Millisecond, "latency is added to both the request and the response")
+	})
+}
+
+func TestStreams(t *testing.T) {
+	n := NewNetwork()
+
+	h := &echoHandler{done: make(chan error, 1)}
+	startServiceWithHandler(t, n, "a", h)
+	b := startService(t, n, "b")
+
+	t.Run("server", func(t *testing.T) {
+		st, err := b.Client().Stream("a", "echo.Count")
+		require.Nil(t, err)
+		defer st.Close()
+
+		require.Nil(t, st.Send(3))
+
+		var got []int
+		for {
+			var i int
+			if err := st.Receive(&i); err == io.EOF {
+				break
+			} else {
+				require.Nil(t, err)
+			}
+			got = append(got, i)
+		}
+
+		assert.Equal(t, []int{1, 2, 3}, got)
+	})
+	t.Run("client", func(t *testing.T) {
+		st, err := b.Client().Stream("a", "echo.Sum")
+		require.Nil(t, err)
+		defer st.Close()
+
+		for i := 1; i <= 4; i++ {
+			require.Nil(t, st.Send(i))
+		}
+		require.Nil(t, st.CloseSend())
+
+		var sum int
+		require.Nil(t, st.Receive(&sum))
+		assert.Equal(t, 10, sum)
+
+		assert.Equal(t, io.EOF, st.Receive(&sum))
+	})
+	t.Run("more messages than the window", func(t *testing.T) {
+		n := 5 * transport.StreamWindow
+
+		st, err := b.Client().Stream("a", "echo.Count")
+		require.Nil(t, err)
+		defer st.Close()
+
+		require.Nil(t, st.Send(n))
+
+		// Give the endpoint time to send as many messages as it can
+		time.Sleep(10 * time.Millisecond)
+
+		count := 0
+		for {
+			var i int
+			if err := st.Receive(&i); err == io.EOF {
+				break
+			} else {
+				require.Nil(t, err)
+			}
+			count++
+		}
+		assert.Equal(t, n, count)
+
+		st, err = b.Client().Stream("a", "echo.Sum")
+		require.Nil(t, err)
+		defer st.Close()
+
+		for i := 0; i < n; i++ {
+			require.Nil(t, st.Send(1))
+		}
+		require.Nil(t, st.CloseSend())
+
+		var sum int
+		require.Nil(t, st.Receive(&sum))
+		assert.Equal(t, n, sum)
+	})
+	t.Run("bidirectional", func(t *testing.T) {
+		st, err := b.Client().Stream("a", "echo.Chat")
+		require.Nil(t, err)
+		defer st.Close()
+
+		for _, text := range []string{"hello", "world"} {
+			require.Nil(t, st.Send(&echoRequest{text}))
+
+			var resp echoResponse
+			require.Nil(t, st.Receive(&resp))
+			assert.Equal(t, text, resp.Text)
+		}
+
+		require.Nil(t, st.Send(&echoRequest{""}))
+
+		var resp echoResponse
+		err = st.Receive(&resp)
+
+		var merr *errors.Error
+		require.ErrorAs(t, err, &merr)
+		assert.EqualValues(t, 400, merr.StatusCode)
+	})
+	t.Run("cancel", func(t *testing.T) {
+		st, err := b.Client().Stream("a", "echo.Wait")
+		require.Nil(t, err)
+
+		require.Nil(t, st.Close())
+
+		select {
+		case err := <-h.done:
+			assert.Equal(t, context.Canceled, err)
+		case <-time.After(time.Second):
+			t.Fatal("handler wasn't cancelled")
+		}
+	})
+	t.Run("not a stream", func(t *testing.T) {
+		st, err := b.Client().Stream("a", "echo.Echo")
+		require.Nil(t, err)
+		defer st.Close()
+
+		var resp echoResponse
+		assert.NotNil(t, st.Receive(&resp))
+
+		err = b.Client().Call("a", "echo.Count", &echoRequest{"hello"}, &resp)
+		assert.NotNil(t, err)
+	})
+}
+
+func TestCodecFallback(t *testing.T) {
+	n := NewNetwork()
+
+	// b prefers MessagePack but still accepts JSON, while a only supports JSON
+	a := startService(t, n, "a")
+	b := startServiceWithHandler(t, n, "b", &echoHandler{}, msgpack.Codec())
+
+	var resp echoResponse
+	err := b.Client().Call("a", "echo.Echo", &echoRequest{"hello"}, &resp)
+
+	require.Nil(t, err)
+	assert.Equal(t, "hello", resp.Text)
+
+	err = a.Client().Call("b", "echo.Echo", &echoRequest{"world"}, &resp)
+
+	require.Nil(t, err)
+	assert.Equal(t, "world", resp.Text)
+}
@@ -0,0 +1,128 @@
+package memory
+
+import (
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// Faults describes the faults that will be injected into the messages sent through a network
+type Faults struct {
+	// Latency is added before delivering every message
+	Latency time.Duration
+
+	// DropRate is the probability, between 0 and 1, of a message being silently dropped
+	DropRate float64
+
+	// ErrorRate is the probability, between 0 and 1, of sending a message failing with ErrInjected
+	ErrorRate float64
+}
+
+// Network connects the listeners and sockets of all the memory transports that use it
+type Network struct {
+	listeners map[string]*memoryListener
+	faults    map[string]Faults
+	defaults  Faults
+	rand      *rand.Rand
+	mu        sync.Mutex
+}
+
+var defaultNetwork = NewNetwork()
+
+// NewNetwork creates an empty network. Transports use a process-wide network by default, so a separate one
+// is only needed to isolate groups of services from each other, like in parallel tests.
+func NewNetwork() *Network {
+	return &Network{
+		listeners: make(map[string]*memoryListener),
+		faults:    make(map[string]Faults),
+		rand:      rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// SetFaults sets the faults injected into messages sent to addresses that don't have their own faults set
+func (n *Network) SetFaults(f Faults) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.defaults = f
+}
+
+// SetAddrFaults sets the faults injected into the messages sent to and from the listener at addr
+func (n *Network) SetAddrFaults(addr string, f Faults) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.faults[addr] = f
+}
+
+// ClearFaults removes all injected faults
+func (n *Network) ClearFaults() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.defaults = Faults{}
+	n.faults = make(map[string]Faults)
+}
+
+func (n *Network) getFaults(addr string) Faults {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if f, ok := n.faults[addr]; ok {
+		return f
+	}
+	return n.defaults
+}
+
+// chance returns true with the given probability
+func (n *Network) chance(p float64) bool {
+	if p <= 0 {
+		return false
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return n.rand.Float64() < p
+}
+
+func (n *Network) register(addr string, l *memoryListener) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if _, ok := n.listeners[addr]; ok {
+		return ErrAddressInUse
+	}
+
+	n.listeners[addr] = l
+	return nil
+}
+
+func (n *Network) unregister(addr string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	delete(n.listeners, addr)
+}
+
+func (n *Network) getListener(addr string) (*memoryListener, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	l, ok := n.listeners[addr]
+	return l, ok
+}
+
+// hasHost returns true if there is a listener on any port of host
+func (n *Network) hasHost(host string) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for addr := range n.listeners {
+		if h, _, err := net.SplitHostPort(addr); err == nil && h == host {
+			return true
+		}
+	}
+	return false
+}
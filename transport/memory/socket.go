@@ -0,0 +1,146 @@
+package memory
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/MouseHatGames/mice/transport"
+)
+
+// socketBuffer is the number of messages that can be sent to a socket before sending blocks
+const socketBuffer = 16
+
+type memorySocket struct {
+	network *Network
+	addr    string
+
+	in  <-chan *transport.Message
+	out chan<- *transport.Message
+
+	closed     chan struct{}
+	peerClosed <-chan struct{}
+	closeOnce  sync.Once
+
+	// listenerClosed is closed once the listener that accepted the socket is closed, which makes it stop receiving
+	// requests. It's nil for dialed sockets.
+	listenerClosed <-chan struct{}
+}
+
+var _ transport.Socket = (*memorySocket)(nil)
+
+// newSocketPair creates two connected sockets
+func newSocketPair(n *Network, addr string) (client, server *memorySocket) {
+	c2s := make(chan *transport.Message, socketBuffer)
+	s2c := make(chan *transport.Message, socketBuffer)
+	clientClosed := make(chan struct{})
+	serverClosed := make(chan struct{})
+
+	client = &memorySocket{
+		network:    n,
+		addr:       addr,
+		in:         s2c,
+		out:        c2s,
+		closed:     clientClosed,
+		peerClosed: serverClosed,
+	}
+	server = &memorySocket{
+		network:    n,
+		addr:       addr,
+		in:         c2s,
+		out:        s2c,
+		closed:     serverClosed,
+		peerClosed: clientClosed,
+	}
+	return
+}
+
+func (s *memorySocket) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.closed)
+	})
+	return nil
+}
+
+func (s *memorySocket) Send(ctx context.Context, msg *transport.Message) error {
+	f := s.network.getFaults(s.addr)
+
+	if s.network.chance(f.ErrorRate) {
+		return ErrInjected
+	}
+
+	if f.Latency > 0 {
+		t := time.NewTimer(f.Latency)
+		defer t.Stop()
+
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	if s.network.chance(f.DropRate) {
+		return nil
+	}
+
+	select {
+	case s.out <- copyMessage(msg):
+		return nil
+	case <-s.closed:
+		return io.ErrClosedPipe
+	case <-s.peerClosed:
+		return io.ErrClosedPipe
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *memorySocket) Receive(ctx context.Context, msg *transport.Message) error {
+	select {
+	case m := <-s.in:
+		*msg = *m
+		return nil
+
+	case <-s.peerClosed:
+		// Deliver any message that was sent before the peer closed
+		select {
+		case m := <-s.in:
+			*msg = *m
+			return nil
+		default:
+			return io.EOF
+		}
+
+	case <-s.listenerClosed:
+		// Requests that were sent before the listener closed are still handled
+		select {
+		case m := <-s.in:
+			*msg = *m
+			return nil
+		default:
+			return io.EOF
+		}
+
+	case <-s.closed:
+		return io.EOF
+
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// copyMessage copies a message so that the receiver doesn't share any memory with the sender
+func copyMessage(msg *transport.Message) *transport.Message {
+	c := &transport.Message{
+		MessageHeaders: make(transport.MessageHeaders, len(msg.MessageHeaders)),
+		Data:           append([]byte(nil), msg.Data...),
+	}
+
+	for k, v := range msg.MessageHeaders {
+		c.MessageHeaders[k] = v
+	}
+
+	return c
+}
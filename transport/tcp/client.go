@@ -0,0 +1,301 @@
+package tcp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/MouseHatGames/mice/logger"
+	"github.com/MouseHatGames/mice/transport"
+)
+
+var ErrMissingRequestID = errors.New("message has no request id")
+var ErrConnectionClosed = errors.New("connection closed")
+var ErrSocketOverflow = errors.New("responses were received faster than they were read")
+
+// socketBuffer is the number of responses that can be waiting to be received by a socket. Streams read their messages
+// as they arrive and limit them with transport.StreamFlow, so there's room for a whole window and its acknowledgements.
+const socketBuffer = 2 * transport.StreamWindow
+
+// clientConn is a persistent connection to a server that is shared by multiple sockets
+type clientConn struct {
+	conn    net.Conn
+	log     logger.Logger
+	maxSize uint32
+
+	w   *bufio.Writer
+	wmu sync.Mutex
+
+	// pending maps the request IDs of the requests that are waiting for responses to their sockets
+	pending map[string]*tcpClientSocket
+	err     error
+	done    chan struct{}
+	mu      sync.Mutex
+}
+
+func newClientConn(nc net.Conn, log logger.Logger, maxSize uint32) *clientConn {
+	c := &clientConn{
+		conn:    nc,
+		log:     log,
+		maxSize: maxSize,
+		w:       bufio.NewWriter(nc),
+		pending: make(map[string]*tcpClientSocket),
+		done:    make(chan struct{}),
+	}
+
+	go c.readLoop()
+
+	return c
+}
+
+func (c *clientConn) readLoop() {
+	r := bufio.NewReader(c.conn)
+
+	for {
+		msg := &transport.Message{}
+
+		if err := readMessage(r, msg, c.maxSize); err != nil {
+			if errors.Is(err, io.EOF) {
+				err = ErrConnectionClosed
+			}
+
+			c.fail(err)
+			return
+		}
+
+		id := msg.MessageHeaders[transport.HeaderRequestID]
+
+		c.mu.Lock()
+		soc, ok := c.pending[id]
+		c.mu.Unlock()
+
+		if !ok {
+			c.log.Debugf("dropping response to unknown request %s", id)
+			continue
+		}
+
+		// A request may get multiple responses if it's a stream. Waiting for the socket to make room for them would
+		// stall every other request on the connection, so a socket that isn't keeping up fails instead.
+		select {
+		case soc.resp <- msg:
+		case <-soc.closed:
+			c.log.Debugf("dropping response to closed request %s", id)
+		default:
+			c.log.Errorf("socket for request %s isn't reading its responses, failing it", id)
+			soc.overflow()
+		}
+	}
+}
+
+// fail closes the connection, making all pending and future requests fail with err
+func (c *clientConn) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return
+	}
+
+	c.log.Debugf("connection to %s closed: %s", c.conn.RemoteAddr(), err)
+
+	c.err = err
+	c.pending = nil
+	close(c.done)
+	c.conn.Close()
+}
+
+func (c *clientConn) isClosed() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *clientConn) register(id string, soc *tcpClientSocket) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return c.err
+	}
+
+	c.pending[id] = soc
+	return nil
+}
+
+func (c *clientConn) unregister(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.pending != nil {
+		delete(c.pending, id)
+	}
+}
+
+func (c *clientConn) write(ctx context.Context, msg *transport.Message) error {
+	if err := validateMessage(msg, c.maxSize); err != nil {
+		return &transport.InvalidMessageError{Err: err}
+	}
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		c.conn.SetWriteDeadline(deadline)
+	} else {
+		c.conn.SetWriteDeadline(time.Time{})
+	}
+
+	if err := writeMessage(c.w, msg, c.maxSize); err != nil {
+		var nerr net.Error
+		if errors.As(err, &nerr) {
+			// The stream may contain a partial frame now, so it can't be used anymore
+			c.fail(err)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// tcpClientSocket sends requests over a shared connection and receives the responses to them
+type tcpClientSocket struct {
+	conn *clientConn
+	resp chan *transport.Message
+
+	// ids holds the request IDs that have been sent through the socket
+	ids   []string
+	idsMu sync.Mutex
+
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	// overflowed is closed once the socket has missed a response because its buffer was full
+	overflowed   chan struct{}
+	overflowOnce sync.Once
+}
+
+var _ transport.Socket = (*tcpClientSocket)(nil)
+
+func newClientSocket(conn *clientConn) *tcpClientSocket {
+	return &tcpClientSocket{
+		conn:       conn,
+		resp:       make(chan *transport.Message, socketBuffer),
+		closed:     make(chan struct{}),
+		overflowed: make(chan struct{}),
+	}
+}
+
+// overflow makes the socket fail, since it can't be trusted to receive all responses anymore
+func (s *tcpClientSocket) overflow() {
+	s.overflowOnce.Do(func() {
+		close(s.overflowed)
+		s.unregisterAll()
+	})
+}
+
+func (s *tcpClientSocket) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.closed)
+		s.unregisterAll()
+	})
+	return nil
+}
+
+func (s *tcpClientSocket) unregisterAll() {
+	s.idsMu.Lock()
+	defer s.idsMu.Unlock()
+
+	for _, id := range s.ids {
+		s.conn.unregister(id)
+	}
+}
+
+// register registers a request ID with the connection, returning false if it already was
+func (s *tcpClientSocket) register(id string) (bool, error) {
+	s.idsMu.Lock()
+	defer s.idsMu.Unlock()
+
+	for _, v := range s.ids {
+		if v == id {
+			return false, nil
+		}
+	}
+
+	if err := s.conn.register(id, s); err != nil {
+		return false, err
+	}
+	s.ids = append(s.ids, id)
+
+	return true, nil
+}
+
+func (s *tcpClientSocket) hasIDs() bool {
+	s.idsMu.Lock()
+	defer s.idsMu.Unlock()
+
+	return len(s.ids) > 0
+}
+
+func (s *tcpClientSocket) Send(ctx context.Context, msg *transport.Message) error {
+	id, ok := msg.MessageHeaders[transport.HeaderRequestID]
+	if !ok {
+		return ErrMissingRequestID
+	}
+
+	// Streams send multiple messages with the same request ID, which only need to be registered once
+	registered, err := s.register(id)
+	if err != nil {
+		return err
+	}
+
+	if err := s.conn.write(ctx, msg); err != nil {
+		if registered {
+			s.conn.unregister(id)
+		}
+		return fmt.Errorf("write message: %w", err)
+	}
+
+	return nil
+}
+
+func (s *tcpClientSocket) Receive(ctx context.Context, msg *transport.Message) error {
+	if !s.hasIDs() {
+		return io.EOF
+	}
+
+	select {
+	case <-s.overflowed:
+		return ErrSocketOverflow
+	default:
+	}
+
+	select {
+	case <-s.overflowed:
+		return ErrSocketOverflow
+
+	case resp := <-s.resp:
+		*msg = *resp
+		return nil
+
+	case <-s.conn.done:
+		// The response may have arrived right before the connection was closed
+		select {
+		case resp := <-s.resp:
+			*msg = *resp
+			return nil
+		default:
+			return s.conn.err
+		}
+
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
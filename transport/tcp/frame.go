@@ -0,0 +1,130 @@
+package tcp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/MouseHatGames/mice/transport"
+)
+
+// A frame contains a single message, laid out as follows (all integers are big-endian):
+//
+//   uint8   header count
+//   for every header:
+//     uint8   name length
+//     []byte  name
+//     uint16  value length
+//     []byte  value
+//   uint32  body length
+//   []byte  body
+
+var ErrHeaderValueTooLong = errors.New("header value is longer than 65535 bytes")
+var ErrFrameTooLarge = errors.New("message body is too large")
+
+func writeMessage(w *bufio.Writer, msg *transport.Message, maxBodySize uint32) error {
+	// Validate the message before writing anything so that the stream isn't left with a partial frame
+	if err := validateMessage(msg, maxBodySize); err != nil {
+		return err
+	}
+
+	var buf [4]byte
+
+	w.WriteByte(byte(len(msg.MessageHeaders)))
+
+	for k, v := range msg.MessageHeaders {
+		w.WriteByte(byte(len(k)))
+		w.WriteString(k)
+
+		binary.BigEndian.PutUint16(buf[:2], uint16(len(v)))
+		w.Write(buf[:2])
+		w.WriteString(v)
+	}
+
+	binary.BigEndian.PutUint32(buf[:], uint32(len(msg.Data)))
+	w.Write(buf[:])
+	w.Write(msg.Data)
+
+	return w.Flush()
+}
+
+// validateMessage returns an error if msg can't be written in a frame
+func validateMessage(msg *transport.Message, maxBodySize uint32) error {
+	if len(msg.MessageHeaders) > math.MaxUint8 {
+		return transport.ErrTooManyHeaders
+	}
+	for k, v := range msg.MessageHeaders {
+		if len(k) > math.MaxUint8 {
+			return transport.ErrHeaderTooLong
+		}
+		if len(v) > math.MaxUint16 {
+			return ErrHeaderValueTooLong
+		}
+	}
+	if uint64(len(msg.Data)) > uint64(maxBodySize) {
+		return ErrFrameTooLarge
+	}
+
+	return nil
+}
+
+// readMessage reads a frame into msg. It returns io.EOF only if the stream ended before the frame started.
+func readMessage(r *bufio.Reader, msg *transport.Message, maxBodySize uint32) error {
+	count, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+
+	msg.MessageHeaders = make(transport.MessageHeaders, count)
+
+	var buf [4]byte
+
+	for i := 0; i < int(count); i++ {
+		keylen, err := r.ReadByte()
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+
+		key := make([]byte, keylen)
+		if _, err := io.ReadFull(r, key); err != nil {
+			return unexpectedEOF(err)
+		}
+
+		if _, err := io.ReadFull(r, buf[:2]); err != nil {
+			return unexpectedEOF(err)
+		}
+
+		value := make([]byte, binary.BigEndian.Uint16(buf[:2]))
+		if _, err := io.ReadFull(r, value); err != nil {
+			return unexpectedEOF(err)
+		}
+
+		msg.MessageHeaders[string(key)] = string(value)
+	}
+
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return unexpectedEOF(err)
+	}
+
+	bodylen := binary.BigEndian.Uint32(buf[:])
+	if bodylen > maxBodySize {
+		return fmt.Errorf("read body of %d bytes: %w", bodylen, ErrFrameTooLarge)
+	}
+
+	msg.Data = make([]byte, bodylen)
+	if _, err := io.ReadFull(r, msg.Data); err != nil {
+		return unexpectedEOF(err)
+	}
+
+	return nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,74 @@
+package tcp
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/MouseHatGames/mice/transport"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFrameRoundTrip(t *testing.T) {
+	var b bytes.Buffer
+
+	msg := transport.NewMessage()
+	msg.SetPath("handler.Method")
+	msg.SetRandomRequestID()
+	msg.Data = []byte("hello")
+
+	assert.Nil(t, writeMessage(bufio.NewWriter(&b), msg, 1024))
+	assert.Nil(t, writeMessage(bufio.NewWriter(&b), &transport.Message{}, 1024))
+
+	r := bufio.NewReader(&b)
+
+	var out transport.Message
+	assert.Nil(t, readMessage(r, &out, 1024))
+	assert.Equal(t, msg.MessageHeaders, out.MessageHeaders)
+	assert.Equal(t, msg.Data, out.Data)
+
+	assert.Nil(t, readMessage(r, &out, 1024))
+	assert.Empty(t, out.MessageHeaders)
+	assert.Empty(t, out.Data)
+
+	assert.Equal(t, io.EOF, readMessage(r, &out, 1024))
+}
+
+func TestFrameLimits(t *testing.T) {
+	var b bytes.Buffer
+	w := bufio.NewWriter(&b)
+
+	msg := transport.NewMessage()
+	msg.MessageHeaders[strings.Repeat("a", 256)] = ""
+	assert.Equal(t, transport.ErrHeaderTooLong, writeMessage(w, msg, 1024))
+
+	msg = transport.NewMessage()
+	for i := 0; i < 256; i++ {
+		msg.MessageHeaders[strings.Repeat("a", i)] = ""
+	}
+	assert.Equal(t, transport.ErrTooManyHeaders, writeMessage(w, msg, 1024))
+
+	msg = &transport.Message{Data: make([]byte, 10)}
+	assert.Equal(t, ErrFrameTooLarge, writeMessage(w, msg, 5))
+
+	assert.Zero(t, b.Len(), "nothing must be written if the message is invalid")
+
+	assert.Nil(t, writeMessage(w, msg, 10))
+	assert.ErrorIs(t, readMessage(bufio.NewReader(&b), msg, 5), ErrFrameTooLarge)
+}
+
+func TestFrameTruncated(t *testing.T) {
+	var b bytes.Buffer
+
+	msg := transport.NewMessage()
+	msg.SetPath("handler.Method")
+	msg.Data = []byte("hello")
+	writeMessage(bufio.NewWriter(&b), msg, 1024)
+
+	truncated := bytes.NewReader(b.Bytes()[:b.Len()-1])
+
+	var out transport.Message
+	assert.Equal(t, io.ErrUnexpectedEOF, readMessage(bufio.NewReader(truncated), &out, 1024))
+}
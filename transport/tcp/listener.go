@@ -0,0 +1,135 @@
+package tcp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/MouseHatGames/mice/logger"
+	"github.com/MouseHatGames/mice/transport"
+)
+
+type tcpListener struct {
+	ln      net.Listener
+	log     logger.Logger
+	maxSize uint32
+
+	conns  map[*tcpServerSocket]struct{}
+	closed bool
+	mu     sync.Mutex
+}
+
+// Close stops accepting new connections and requests. Responses to requests that have already been received
+// can still be sent.
+func (l *tcpListener) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.closed = true
+
+	// Unblock the sockets waiting for a request
+	for s := range l.conns {
+		s.conn.SetReadDeadline(time.Now())
+	}
+
+	return l.ln.Close()
+}
+
+func (l *tcpListener) isClosed() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.closed
+}
+
+func (l *tcpListener) Accept(ctx context.Context, fn func(transport.Socket)) error {
+	l.log.Debugf("accepting connections")
+
+	for {
+		conn, err := l.ln.Accept()
+		if err != nil {
+			if l.isClosed() {
+				return nil
+			}
+			return err
+		}
+
+		l.log.Debugf("got connection from %s", conn.RemoteAddr())
+
+		s := &tcpServerSocket{
+			conn: conn,
+			r:    bufio.NewReader(conn),
+			w:    bufio.NewWriter(conn),
+			l:    l,
+		}
+
+		l.mu.Lock()
+		if l.closed {
+			l.mu.Unlock()
+			conn.Close()
+			return nil
+		}
+		l.conns[s] = struct{}{}
+		l.mu.Unlock()
+
+		fn(s)
+	}
+}
+
+func (l *tcpListener) untrack(s *tcpServerSocket) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.conns, s)
+}
+
+// tcpServerSocket receives requests from a connection and sends back responses to them
+type tcpServerSocket struct {
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+	wmu  sync.Mutex
+	l    *tcpListener
+}
+
+var _ transport.Socket = (*tcpServerSocket)(nil)
+
+func (s *tcpServerSocket) Close() error {
+	s.l.untrack(s)
+	return s.conn.Close()
+}
+
+func (s *tcpServerSocket) Send(ctx context.Context, msg *transport.Message) error {
+	if err := validateMessage(msg, s.l.maxSize); err != nil {
+		return &transport.InvalidMessageError{Err: err}
+	}
+
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		s.conn.SetWriteDeadline(deadline)
+	} else {
+		s.conn.SetWriteDeadline(time.Time{})
+	}
+
+	return writeMessage(s.w, msg, s.l.maxSize)
+}
+
+func (s *tcpServerSocket) Receive(ctx context.Context, msg *transport.Message) error {
+	err := readMessage(s.r, msg, s.l.maxSize)
+	if err == nil {
+		return nil
+	}
+
+	var nerr net.Error
+	if errors.Is(err, io.EOF) || (s.l.isClosed() && errors.As(err, &nerr) && nerr.Timeout()) {
+		return io.EOF
+	}
+
+	return err
+}
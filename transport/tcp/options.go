@@ -0,0 +1,44 @@
+package tcp
+
+import "time"
+
+// Options holds the configuration of the TCP transport
+type Options struct {
+	DialTimeout time.Duration
+	KeepAlive   time.Duration
+
+	// MaxMessageSize is the maximum size of the body of a message, which is checked both when sending and receiving
+	MaxMessageSize uint32
+}
+
+// Option represents a function that can be used to mutate an Options object
+type Option func(*Options)
+
+func defaultOptions() Options {
+	return Options{
+		DialTimeout:    5 * time.Second,
+		KeepAlive:      30 * time.Second,
+		MaxMessageSize: 32 << 20,
+	}
+}
+
+// DialTimeout sets the maximum time to wait for a connection to be established. Defaults to 5 seconds
+func DialTimeout(d time.Duration) Option {
+	return func(o *Options) {
+		o.DialTimeout = d
+	}
+}
+
+// KeepAlive sets the interval between TCP keep-alive probes. Defaults to 30 seconds
+func KeepAlive(d time.Duration) Option {
+	return func(o *Options) {
+		o.KeepAlive = d
+	}
+}
+
+// MaxMessageSize sets the maximum size in bytes of the body of a message. Defaults to 32 MiB
+func MaxMessageSize(n uint32) Option {
+	return func(o *Options) {
+		o.MaxMessageSize = n
+	}
+}
@@ -0,0 +1,103 @@
+package tcp
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/MouseHatGames/mice/logger"
+	"github.com/MouseHatGames/mice/options"
+	"github.com/MouseHatGames/mice/transport"
+)
+
+type tcpTransport struct {
+	log  logger.Logger
+	opts Options
+
+	conns   map[string]*connEntry
+	connsMu sync.Mutex
+}
+
+// connEntry holds the persistent connection to an address, which is shared by all calls to it
+type connEntry struct {
+	mu   sync.Mutex
+	conn *clientConn
+}
+
+// Transport sets a TCP transport as the service's transport. Calls to the same address are multiplexed
+// over a single persistent connection, with responses being matched to their requests by request ID.
+func Transport(opts ...Option) options.Option {
+	return func(o *options.Options) {
+		topts := defaultOptions()
+		for _, opt := range opts {
+			opt(&topts)
+		}
+
+		o.Transport = &tcpTransport{
+			log:   o.Logger.GetLogger("tcp"),
+			opts:  topts,
+			conns: make(map[string]*connEntry),
+		}
+	}
+}
+
+func (t *tcpTransport) Listen(ctx context.Context, addr string) (transport.Listener, error) {
+	lc := net.ListenConfig{KeepAlive: t.opts.KeepAlive}
+
+	ln, err := lc.Listen(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("listen: %w", err)
+	}
+
+	t.log.Infof("listening on %s", addr)
+
+	return &tcpListener{
+		ln:      ln,
+		log:     t.log,
+		maxSize: t.opts.MaxMessageSize,
+		conns:   make(map[*tcpServerSocket]struct{}),
+	}, nil
+}
+
+func (t *tcpTransport) Dial(ctx context.Context, addr string) (transport.Socket, error) {
+	conn, err := t.getConn(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return newClientSocket(conn), nil
+}
+
+// getConn returns the connection to addr, establishing it if there isn't one or if it has been closed
+func (t *tcpTransport) getConn(ctx context.Context, addr string) (*clientConn, error) {
+	t.connsMu.Lock()
+	entry, ok := t.conns[addr]
+	if !ok {
+		entry = &connEntry{}
+		t.conns[addr] = entry
+	}
+	t.connsMu.Unlock()
+
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+
+	if entry.conn != nil && !entry.conn.isClosed() {
+		return entry.conn, nil
+	}
+
+	t.log.Debugf("dialing %s", addr)
+
+	dialer := net.Dialer{
+		Timeout:   t.opts.DialTimeout,
+		KeepAlive: t.opts.KeepAlive,
+	}
+
+	nc, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	entry.conn = newClientConn(nc, t.log, t.opts.MaxMessageSize)
+	return entry.conn, nil
+}
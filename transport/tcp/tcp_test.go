@@ -0,0 +1,266 @@
+package tcp
+
+import (
+	"context"
+	"io"
+	"math"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MouseHatGames/mice/logger/stdout"
+	"github.com/MouseHatGames/mice/options"
+	"github.com/MouseHatGames/mice/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTransport() *tcpTransport {
+	o := &options.Options{Logger: stdout.NewStdoutLogger(" ")}
+	Transport()(o)
+	return o.Transport.(*tcpTransport)
+}
+
+// listenEcho starts a listener that sends back every request it receives after the duration in its data,
+// handling requests concurrently
+func listenEcho(t *testing.T, tr transport.Transport) (transport.Listener, string) {
+	l, err := tr.Listen(context.Background(), "127.0.0.1:0")
+	require.Nil(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	go l.Accept(context.Background(), func(soc transport.Socket) {
+		go func() {
+			defer soc.Close()
+
+			for {
+				msg := &transport.Message{}
+				if err := soc.Receive(context.Background(), msg); err != nil {
+					return
+				}
+
+				go func() {
+					d, _ := time.ParseDuration(string(msg.Data))
+					time.Sleep(d)
+					soc.Send(context.Background(), msg)
+				}()
+			}
+		}()
+	})
+
+	return l, l.(*tcpListener).ln.Addr().String()
+}
+
+func call(t *testing.T, tr transport.Transport, addr string, data string) *transport.Message {
+	soc, err := tr.Dial(context.Background(), addr)
+	require.Nil(t, err)
+	defer soc.Close()
+
+	req := transport.NewMessage()
+	req.SetRandomRequestID()
+	req.Data = []byte(data)
+
+	require.Nil(t, soc.Send(context.Background(), req))
+
+	var resp transport.Message
+	require.Nil(t, soc.Receive(context.Background(), &resp))
+
+	assert.Equal(t, req.MustGetRequestID(), resp.MustGetRequestID())
+	return &resp
+}
+
+func TestMultiplexing(t *testing.T) {
+	tr := newTransport()
+	_, addr := listenEcho(t, tr)
+
+	var wg sync.WaitGroup
+	order := make(chan string, 2)
+
+	for _, d := range []string{"100ms", "0s"} {
+		wg.Add(1)
+
+		go func(d string) {
+			defer wg.Done()
+
+			resp := call(t, tr, addr, d)
+			order <- string(resp.Data)
+		}(d)
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	wg.Wait()
+	close(order)
+
+	// The fast request must not wait for the slow one even though they share a connection
+	assert.Equal(t, "0s", <-order)
+	assert.Equal(t, "100ms", <-order)
+	assert.Len(t, tr.conns, 1)
+}
+
+func TestReconnect(t *testing.T) {
+	tr := newTransport()
+	_, addr := listenEcho(t, tr)
+
+	call(t, tr, addr, "0s")
+
+	tr.conns[addr].conn.conn.Close()
+	time.Sleep(10 * time.Millisecond)
+
+	call(t, tr, addr, "0s")
+}
+
+func TestListenerClose(t *testing.T) {
+	tr := newTransport()
+	l, err := tr.Listen(context.Background(), "127.0.0.1:0")
+	require.Nil(t, err)
+
+	accepted := make(chan transport.Socket, 1)
+	done := make(chan error, 1)
+
+	go func() {
+		done <- l.Accept(context.Background(), func(soc transport.Socket) {
+			accepted <- soc
+		})
+	}()
+
+	_, err = tr.Dial(context.Background(), l.(*tcpListener).ln.Addr().String())
+	require.Nil(t, err)
+
+	soc := <-accepted
+	require.Nil(t, l.Close())
+
+	var msg transport.Message
+	assert.Equal(t, io.EOF, soc.Receive(context.Background(), &msg))
+	assert.Nil(t, <-done)
+}
+
+func TestMultipleResponses(t *testing.T) {
+	tr := newTransport()
+
+	l, err := tr.Listen(context.Background(), "127.0.0.1:0")
+	require.Nil(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	// Reply to every request three times, like a stream would
+	go l.Accept(context.Background(), func(soc transport.Socket) {
+		go func() {
+			defer soc.Close()
+
+			msg := &transport.Message{}
+			if err := soc.Receive(context.Background(), msg); err != nil {
+				return
+			}
+
+			for i := 0; i < 3; i++ {
+				soc.Send(context.Background(), msg)
+			}
+		}()
+	})
+
+	soc, err := tr.Dial(context.Background(), l.(*tcpListener).ln.Addr().String())
+	require.Nil(t, err)
+	defer soc.Close()
+
+	req := transport.NewMessage()
+	req.SetRandomRequestID()
+	require.Nil(t, soc.Send(context.Background(), req))
+
+	for i := 0; i < 3; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+
+		var resp transport.Message
+		require.Nil(t, soc.Receive(ctx, &resp))
+		assert.Equal(t, req.MustGetRequestID(), resp.MustGetRequestID())
+
+		cancel()
+	}
+}
+
+func TestSlowSocket(t *testing.T) {
+	tr := newTransport()
+
+	l, err := tr.Listen(context.Background(), "127.0.0.1:0")
+	require.Nil(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	// Reply to requests with "flood" in their data more times than a socket can buffer
+	go l.Accept(context.Background(), func(soc transport.Socket) {
+		go func() {
+			defer soc.Close()
+
+			for {
+				msg := &transport.Message{}
+				if err := soc.Receive(context.Background(), msg); err != nil {
+					return
+				}
+
+				n := 1
+				if string(msg.Data) == "flood" {
+					n = 2 * socketBuffer
+				}
+
+				for i := 0; i < n; i++ {
+					soc.Send(context.Background(), msg)
+				}
+			}
+		}()
+	})
+
+	addr := l.(*tcpListener).ln.Addr().String()
+
+	slow, err := tr.Dial(context.Background(), addr)
+	require.Nil(t, err)
+	defer slow.Close()
+
+	req := transport.NewMessage()
+	req.SetRandomRequestID()
+	req.Data = []byte("flood")
+	require.Nil(t, slow.Send(context.Background(), req))
+
+	// The socket that isn't reading its responses doesn't block the other requests on the connection
+	call(t, tr, addr, "0s")
+	assert.Len(t, tr.conns, 1)
+
+	for {
+		var resp transport.Message
+		if err := slow.Receive(context.Background(), &resp); err != nil {
+			assert.Equal(t, ErrSocketOverflow, err)
+			break
+		}
+	}
+}
+
+func TestSendInvalidMessage(t *testing.T) {
+	tr := newTransport()
+
+	l, err := tr.Listen(context.Background(), "127.0.0.1:0")
+	require.Nil(t, err)
+	defer l.Close()
+
+	sendErr := make(chan error, 1)
+
+	go l.Accept(context.Background(), func(soc transport.Socket) {
+		go func() {
+			defer soc.Close()
+
+			msg := &transport.Message{}
+			if err := soc.Receive(context.Background(), msg); err != nil {
+				return
+			}
+
+			large := *msg
+			large.MessageHeaders = transport.MessageHeaders{"large": strings.Repeat("a", math.MaxUint16+1)}
+			sendErr <- soc.Send(context.Background(), &large)
+
+			soc.Send(context.Background(), msg)
+		}()
+	})
+
+	resp := call(t, tr, l.(*tcpListener).ln.Addr().String(), "hello")
+
+	var ierr *transport.InvalidMessageError
+	assert.ErrorAs(t, <-sendErr, &ierr)
+	assert.ErrorIs(t, ierr, ErrHeaderValueTooLong)
+	assert.Equal(t, "hello", string(resp.Data), "the socket can still be used")
+}
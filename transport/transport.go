@@ -8,6 +8,20 @@ import (
 var ErrTooManyHeaders = errors.New("too many headers")
 var ErrHeaderTooLong = errors.New("header is longer than 255 characters")
 
+// InvalidMessageError is returned by sockets that can't send a message because of its contents, such as its size.
+// Nothing has been sent in that case, so the socket can still be used.
+type InvalidMessageError struct {
+	Err error
+}
+
+func (e *InvalidMessageError) Error() string {
+	return "invalid message: " + e.Err.Error()
+}
+
+func (e *InvalidMessageError) Unwrap() error {
+	return e.Err
+}
+
 type Transport interface {
 	Listen(ctx context.Context, addr string) (Listener, error)
 	Dial(ctx context.Context, addr string) (Socket, error)